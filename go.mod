@@ -7,13 +7,17 @@ toolchain go1.25.8
 replace k8s.io/client-go => k8s.io/client-go v0.20.0
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/blang/semver v3.5.1+incompatible
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/google/go-github/v67 v67.0.0
 	github.com/pkg/errors v0.9.1
 	github.com/rancher/apiserver v0.9.2
 	github.com/rancher/wrangler/v3 v3.4.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.9.4
 	github.com/urfave/cli/v2 v2.4.0
+	go.yaml.in/yaml/v3 v3.0.4
 	sigs.k8s.io/yaml v1.6.0
 )
 
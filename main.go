@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rancher/channelserver/pkg/config"
+	"github.com/rancher/channelserver/pkg/model"
 	"github.com/rancher/channelserver/pkg/server"
+	"github.com/rancher/channelserver/pkg/serverconfig"
 	"github.com/rancher/wrangler/v3/pkg/signals"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -16,7 +21,8 @@ import (
 var (
 	Version              = "v0.0.0-dev"
 	GitCommit            = "HEAD"
-	URLs                 = cli.NewStringSlice("channels.yaml")
+	URLs                 cli.StringSlice
+	Overlays             cli.StringSlice
 	RefreshInterval      string
 	ListenAddress        string
 	SubKeys              cli.StringSlice
@@ -24,6 +30,27 @@ var (
 	PathPrefix           cli.StringSlice
 	AppName              string
 	GithubToken          string
+	CacheDir             string
+	RetryInterval        string
+	RefreshSchedules     cli.StringSlice
+	ConfigFile           string
+	HTTPTimeout          string
+	HTTPCAFile           string
+	HTTPCertFile         string
+	HTTPKeyFile          string
+	HTTPProxy            string
+	HTTPHeaders          cli.StringSlice
+	HTTPBearerToken      string
+	HTTPUsername         string
+	HTTPPassword         string
+	HTTPMaxBodySize      int64
+	PublicKeys           cli.StringSlice
+	SignatureSuffix      string
+	Validation           string
+	IncludeOrigins       cli.StringSlice
+	VariablePrefix       string
+	VariableFileDir      string
+	RemoteVariables      bool
 )
 
 func main() {
@@ -32,22 +59,45 @@ func main() {
 	app.Version = fmt.Sprintf("%s (%s)", Version, GitCommit)
 	app.Flags = []cli.Flag{
 		&cli.StringSliceFlag{
-			Name:    "url",
-			EnvVars: []string{"URL"},
-			Value:   URLs,
+			Name:        "url",
+			Usage:       "source of the channel config, a local path or a URL with one of the registered schemes (" + strings.Join(config.RegisteredSchemes(), ", ") + ")",
+			EnvVars:     []string{"URL"},
+			Value:       cli.NewStringSlice("channels.yaml"),
+			Destination: &URLs,
+		},
+		&cli.StringSliceFlag{
+			Name:        "overlay",
+			Usage:       "source of a JSON patch or strategic merge patch applied to the channel config, in order",
+			EnvVars:     []string{"OVERLAY"},
+			Destination: &Overlays,
 		},
 		&cli.StringSliceFlag{
 			Name:        "config-key",
+			Usage:       "top-level key of the config to serve, or * to serve every top-level key at the path prefix with * replaced by the key",
 			EnvVars:     []string{"SUBKEY"},
 			Value:       cli.NewStringSlice(""),
 			Destination: &SubKeys,
 		},
 		&cli.StringFlag{
 			Name:        "refresh-interval",
+			Usage:       "how often to reload the config, a duration or a cron expression",
 			EnvVars:     []string{"REFRESH_INTERVAL"},
 			Value:       "15m",
 			Destination: &RefreshInterval,
 		},
+		&cli.StringSliceFlag{
+			Name:        "refresh-schedule",
+			Usage:       "refresh interval for a single path prefix as prefix=schedule, where schedule is a duration or a cron expression",
+			EnvVars:     []string{"REFRESH_SCHEDULE"},
+			Destination: &RefreshSchedules,
+		},
+		&cli.StringFlag{
+			Name:        "retry-interval",
+			Usage:       "delay before retrying a failed load, doubled for each consecutive failure up to the refresh interval; 0 waits for the refresh interval",
+			EnvVars:     []string{"RETRY_INTERVAL"},
+			Value:       "1s",
+			Destination: &RetryInterval,
+		},
 		&cli.StringFlag{
 			Name:        "listen-address",
 			EnvVars:     []string{"LISTEN_ADDRESS"},
@@ -76,8 +126,134 @@ func main() {
 			EnvVars:     []string{"GITHUB_TOKEN"},
 			Destination: &GithubToken,
 		},
+		&cli.StringFlag{
+			Name:        "cache-dir",
+			Usage:       "directory to persist the last successfully loaded config in, served if no source can be loaded at startup",
+			EnvVars:     []string{"CACHE_DIR"},
+			Destination: &CacheDir,
+		},
+		&cli.StringFlag{
+			Name:        "validation",
+			Usage:       "how to handle a channel config that does not match the schema, such as one with unknown fields: strict rejects it, warn logs the problems and serves it",
+			EnvVars:     []string{"VALIDATION"},
+			Value:       string(config.ValidationStrict),
+			Destination: &Validation,
+		},
+		&cli.StringFlag{
+			Name:        "config-file",
+			Usage:       "server configuration file with per prefix and per source settings, applied again whenever it changes",
+			EnvVars:     []string{"CONFIG_FILE"},
+			Destination: &ConfigFile,
+		},
+		&cli.StringFlag{
+			Name:        "http-timeout",
+			Usage:       "timeout of requests to config sources",
+			EnvVars:     []string{"HTTP_TIMEOUT"},
+			Value:       "5s",
+			Destination: &HTTPTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "http-ca-file",
+			Usage:       "PEM bundle of certificate authorities to trust for config sources, in addition to the system ones",
+			EnvVars:     []string{"HTTP_CA_FILE"},
+			Destination: &HTTPCAFile,
+		},
+		&cli.StringFlag{
+			Name:        "http-cert-file",
+			Usage:       "client certificate to present to config sources",
+			EnvVars:     []string{"HTTP_CERT_FILE"},
+			Destination: &HTTPCertFile,
+		},
+		&cli.StringFlag{
+			Name:        "http-key-file",
+			Usage:       "key of the client certificate to present to config sources",
+			EnvVars:     []string{"HTTP_KEY_FILE"},
+			Destination: &HTTPKeyFile,
+		},
+		&cli.StringFlag{
+			Name:        "http-proxy",
+			Usage:       "proxy for requests to config sources, overriding the proxy environment variables",
+			EnvVars:     []string{"HTTP_PROXY_URL"},
+			Destination: &HTTPProxy,
+		},
+		&cli.StringSliceFlag{
+			Name:        "http-header",
+			Usage:       "header to send to HTTP config sources, as Name: value",
+			EnvVars:     []string{"HTTP_HEADER"},
+			Destination: &HTTPHeaders,
+		},
+		&cli.StringFlag{
+			Name:        "http-bearer-token",
+			Usage:       "bearer token to send to HTTP config sources",
+			EnvVars:     []string{"HTTP_BEARER_TOKEN"},
+			Destination: &HTTPBearerToken,
+		},
+		&cli.StringFlag{
+			Name:        "http-username",
+			Usage:       "username for basic authentication to HTTP config sources",
+			EnvVars:     []string{"HTTP_USERNAME"},
+			Destination: &HTTPUsername,
+		},
+		&cli.StringFlag{
+			Name:        "http-password",
+			Usage:       "password for basic authentication to HTTP config sources",
+			EnvVars:     []string{"HTTP_PASSWORD"},
+			Destination: &HTTPPassword,
+		},
+		&cli.Int64Flag{
+			Name:        "http-max-body-size",
+			Usage:       "maximum size in bytes of config fetched from HTTP, OCI and S3 sources, 0 for no limit",
+			EnvVars:     []string{"HTTP_MAX_BODY_SIZE"},
+			Destination: &HTTPMaxBodySize,
+		},
+		&cli.StringSliceFlag{
+			Name:        "public-key",
+			Usage:       "PEM file of public keys trusted to sign the config; if set, config without a valid detached signature is not applied",
+			EnvVars:     []string{"PUBLIC_KEY"},
+			Destination: &PublicKeys,
+		},
+		&cli.StringFlag{
+			Name:        "signature-suffix",
+			Usage:       "suffix appended to the location of the config to find its detached signature",
+			EnvVars:     []string{"SIGNATURE_SUFFIX"},
+			Value:       config.DefaultSignatureSuffix,
+			Destination: &SignatureSuffix,
+		},
+		&cli.StringSliceFlag{
+			Name:        "include-origin",
+			Usage:       "origin, such as https://config.example.com, that $include can read files from in addition to the origin of the including file",
+			EnvVars:     []string{"INCLUDE_ORIGIN"},
+			Destination: &IncludeOrigins,
+		},
+		&cli.StringFlag{
+			Name:        "variable-prefix",
+			Usage:       "prefix of the environment variables the config can refer to with ${NAME}",
+			EnvVars:     []string{"VARIABLE_PREFIX"},
+			Value:       config.DefaultVariablePrefix,
+			Destination: &VariablePrefix,
+		},
+		&cli.StringFlag{
+			Name:        "variable-file-dir",
+			Usage:       "directory of the files, such as mounted secrets, the config can refer to with ${file:path}",
+			EnvVars:     []string{"VARIABLE_FILE_DIR"},
+			Destination: &VariableFileDir,
+		},
+		&cli.BoolFlag{
+			Name:        "remote-variables",
+			Usage:       "substitute variables in config loaded from sources other than local files",
+			EnvVars:     []string{"REMOTE_VARIABLES"},
+			Destination: &RemoteVariables,
+		},
 	}
 	app.Action = run
+	app.Commands = []*cli.Command{
+		{
+			Name:      "migrate",
+			Usage:     "rewrite channel config files in the newest version of the config format",
+			ArgsUsage: "FILE...",
+			Action:    migrate,
+		},
+	}
 
 	if err := app.Run(os.Args); err != nil {
 		logrus.Fatal(err)
@@ -88,27 +264,188 @@ func run(c *cli.Context) error {
 	logrus.SetOutput(os.Stderr)
 	ctx := signals.SetupSignalContext()
 
-	intval, err := time.ParseDuration(RefreshInterval)
+	retry, err := time.ParseDuration(RetryInterval)
 	if err != nil {
-		return errors.Wrapf(err, "failed to parse %s", RefreshInterval)
+		return errors.Wrapf(err, "failed to parse %s", RetryInterval)
 	}
+
 	if len(SubKeys.Value()) != len(PathPrefix.Value()) {
 		return errors.Errorf("keys-prefix lengths are not equal %s %s %s ", PathPrefix.Value(), SubKeys.Value(), ListenAddress)
 	}
 
-	var (
-		configs = map[string]*config.Config{}
-		sources []config.Source
-	)
+	schedules := map[string]string{}
+	for _, schedule := range RefreshSchedules.Value() {
+		prefix, spec, ok := strings.Cut(schedule, "=")
+		if !ok || !slices.Contains(PathPrefix.Value(), prefix) {
+			return errors.Errorf("refresh schedule %q must be prefix=schedule for one of the path prefixes %s", schedule, PathPrefix.Value())
+		}
+		schedules[prefix] = spec
+	}
+
+	if _, err := config.ParseValidationMode(Validation); err != nil {
+		return err
+	}
+
+	httpOptions, err := httpOptions()
+	if err != nil {
+		return err
+	}
+
+	var verifier *config.Verifier
+	if len(PublicKeys.Value()) > 0 {
+		verifier, err = config.LoadVerifier(PublicKeys.Value(), SignatureSuffix)
+		if err != nil {
+			return err
+		}
+	}
+
+	runner := &serverconfig.Runner{
+		Handler:     server.NewHandler(nil),
+		HTTPOptions: httpOptions,
+		Verifier:    verifier,
+		CacheDir:    CacheDir,
+		Retry:       retry,
+
+		IncludeOrigins: IncludeOrigins.Value(),
+		Variables: config.Variables{
+			Prefix:  VariablePrefix,
+			FileDir: VariableFileDir,
+			Remote:  RemoteVariables,
+		},
+	}
+	prefixes, err := servedPrefixes(schedules)
+	if err != nil {
+		return err
+	}
+	if err := runner.Apply(ctx, prefixes); err != nil {
+		return err
+	}
+
+	if ConfigFile != "" {
+		file := &config.FileSource{Path: ConfigFile}
+		err := file.Watch(ctx, func() {
+			prefixes, err := servedPrefixes(schedules)
+			if err == nil {
+				err = runner.Apply(ctx, prefixes)
+			}
+			if err != nil {
+				logrus.Errorf("Failed to apply server configuration %s: %v", ConfigFile, err)
+				return
+			}
+			logrus.Infof("Applied server configuration %s", ConfigFile)
+		})
+		if err != nil {
+			logrus.Warnf("Failed to watch %s, changes will not be applied until restart: %v", ConfigFile, err)
+		}
+	}
+
+	return server.Serve(ctx, ListenAddress, runner.Handler)
+}
+
+// migrate rewrites the files given as arguments in the newest version of the
+// config format. Files already in it are left untouched.
+func migrate(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("no config files given")
+	}
+	for _, path := range c.Args().Slice() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		migrated, err := config.Migrate(content, config.DetectFormat(path, "", content))
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate %s", path)
+		}
+		if bytes.Equal(migrated, content) {
+			logrus.Infof("%s is already at %s", path, model.APIVersion)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+			return err
+		}
+		logrus.Infof("Migrated %s to %s", path, model.APIVersion)
+	}
+	return nil
+}
 
+// servedPrefixes returns the prefixes to serve, from the server configuration
+// file if it has any, or from the --config-key and --path-prefix flags.
+func servedPrefixes(schedules map[string]string) ([]serverconfig.Prefix, error) {
+	serverConfig := &serverconfig.Config{}
+	if ConfigFile != "" {
+		var err error
+		serverConfig, err = serverconfig.Load(ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defaults := serverconfig.Prefix{
+		ChannelServerVersion: ChannelServerVersion,
+		AppName:              AppName,
+		GitHubToken:          GithubToken,
+		Refresh:              RefreshInterval,
+		Validation:           Validation,
+	}
 	for _, url := range URLs.Value() {
-		sources = append(sources, config.StringSource(url))
+		defaults.Sources = append(defaults.Sources, serverconfig.Source{URL: url})
 	}
+	for _, url := range Overlays.Value() {
+		defaults.Overlays = append(defaults.Overlays, serverconfig.Source{URL: url})
+	}
+	if len(serverConfig.Prefixes) > 0 {
+		return serverConfig.ServedPrefixes(defaults)
+	}
+
+	defaults = serverConfig.Defaults(defaults)
+	var prefixes []serverconfig.Prefix
 	for index, subkey := range SubKeys.Value() {
-		prefix := PathPrefix.Value()[index]
-		config := config.NewConfig(ctx, subkey, &config.DurationWait{Duration: intval}, ChannelServerVersion, AppName, GithubToken, sources)
-		configs[prefix] = config
-		logrus.Infof("Serving channels from %v with subkey %q at /%s", sources, subkey, prefix)
+		prefix := defaults
+		prefix.Prefix = PathPrefix.Value()[index]
+		prefix.Key = subkey
+		if spec, ok := schedules[prefix.Prefix]; ok {
+			prefix.Refresh = spec
+		}
+		if subkey == config.AllKeys && !strings.Contains(prefix.Prefix, config.AllKeys) {
+			return nil, errors.Errorf("path prefix %q for config key %s must contain %s to be replaced by each key", prefix.Prefix, config.AllKeys, config.AllKeys)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// httpOptions returns the default HTTP options for config sources given
+// with the --http-* flags.
+func httpOptions() (config.HTTPOptions, error) {
+	timeout, err := time.ParseDuration(HTTPTimeout)
+	if err != nil {
+		return config.HTTPOptions{}, errors.Wrapf(err, "failed to parse %s", HTTPTimeout)
 	}
-	return server.ListenAndServe(ctx, ListenAddress, configs)
+
+	headers := map[string]string{}
+	for _, header := range HTTPHeaders.Value() {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return config.HTTPOptions{}, errors.Errorf("HTTP header %q must be of the form Name: value", header)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return config.HTTPOptions{
+		Timeout:     timeout,
+		CAFile:      HTTPCAFile,
+		CertFile:    HTTPCertFile,
+		KeyFile:     HTTPKeyFile,
+		Proxy:       HTTPProxy,
+		BearerToken: HTTPBearerToken,
+		Username:    HTTPUsername,
+		Password:    HTTPPassword,
+		Headers:     headers,
+		MaxBodySize: HTTPMaxBodySize,
+	}, nil
 }
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cacheEntry is the last known good configuration of a subkey, as persisted
+// in the cache directory.
+type cacheEntry struct {
+	Source   string `json:"source"`
+	Revision string `json:"revision,omitempty"`
+	Digest   string `json:"digest"`
+	Content  []byte `json:"content"`
+	// Files are the URLs of the files the content was assembled from.
+	Files      []string  `json:"files,omitempty"`
+	GHReleases []string  `json:"githubReleases,omitempty"`
+	ETag       string    `json:"githubETag,omitempty"`
+	LoadedAt   time.Time `json:"loadedAt"`
+}
+
+func (c *Config) cachePath() string {
+	name := c.subKey
+	if name == "" {
+		name = "default"
+	}
+	return filepath.Join(c.cacheDir, url.PathEscape(name)+".json")
+}
+
+// writeCache persists the applied configuration. If content is nil the
+// previously cached content is kept and only the GitHub releases updated.
+// Failures are logged, as the cache is only a fallback.
+func (c *Config) writeCache(content []byte) {
+	if c.cacheDir == "" {
+		return
+	}
+	if err := c.writeCacheEntry(content); err != nil {
+		logrus.Errorf("Failed to write configuration cache for %s: %v", c.subKey, err)
+	}
+}
+
+func (c *Config) writeCacheEntry(content []byte) error {
+	status := c.Status()
+	entry := &cacheEntry{
+		Source:     status.Source,
+		Revision:   status.Revision,
+		Digest:     c.digest,
+		Content:    content,
+		GHReleases: c.ghReleases,
+		ETag:       c.ghETag,
+		LoadedAt:   status.LoadedAt,
+	}
+	c.Lock()
+	if c.files != nil {
+		entry.Files = slices.Sorted(maps.Keys(c.files.contents))
+	}
+	c.Unlock()
+	if content == nil {
+		cached, err := c.readCache()
+		if err != nil {
+			return err
+		}
+		entry.Content = cached.Content
+		entry.Files = cached.Files
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.cacheDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.cacheDir, ".cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.cachePath())
+}
+
+func (c *Config) readCache() (*cacheEntry, error) {
+	if c.cacheDir == "" {
+		return nil, fmt.Errorf("no cache directory configured")
+	}
+	data, err := os.ReadFile(c.cachePath())
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", c.cachePath(), err)
+	}
+	return entry, nil
+}
+
+// loadCache applies the cached configuration, marking it stale. The cached
+// GitHub releases are used to resolve channels so no source or GitHub
+// request is needed.
+func (c *Config) loadCache(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	entry, err := c.readCache()
+	if err != nil {
+		return err
+	}
+
+	// Cached content is JSON, or YAML as written in the source.
+	doc, err := parseConfig(entry.Content, DetectFormat("", "", entry.Content))
+	if err != nil {
+		return fmt.Errorf("failed to parse cached config: %w", err)
+	}
+	files := entry.Files
+	if len(files) == 0 {
+		files = []string{entry.Source}
+	}
+	document, err := parseDocument(doc, nil, c.subKey, newSourceFiles(entry.Source, nil), c.variables.resolver(files...))
+	if err != nil {
+		return fmt.Errorf("failed to get cached channel config: %w", err)
+	}
+	config := document.ChannelsConfig()
+	releases, err := document.ReleasesConfig(c.channelServerVersion)
+	if err != nil {
+		return fmt.Errorf("failed to get cached release config: %w", err)
+	}
+	appDefaultsConfig := document.AppDefaultsConfig(c.appName)
+
+	gh, err := c.ghClient(config)
+	if err != nil {
+		return err
+	}
+
+	// The digest and validators are left unset so the next successful load
+	// applies the live content even if it is identical.
+	c.setSource(entry.Source, entry.Revision, entry.Digest)
+	return c.applyConfig(gh, config, releases, appDefaultsConfig, entry.GHReleases, "", true, entry.LoadedAt)
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		config  string
+		subKey  string
+		corrupt bool
+		want    []string
+		wantErr string
+	}{
+		{name: "YAML", url: "test://channels.yaml", config: testConfig, subKey: "k3s", want: []string{"stable=v1.30.4+k3s1", "testing=v1.31.0+k3s1"}},
+		{name: "JSON", url: "test://channels.json", config: `{"k3s": {"channels": [{"name": "stable", "latest": "v1.30.4+k3s1"}]}}`, subKey: "k3s", want: []string{"stable=v1.30.4+k3s1"}},
+		{name: "TOML", url: "test://channels.toml", config: "[[k3s.channels]]\nname = \"stable\"\nlatest = \"v1.30.4+k3s1\"\n", subKey: "k3s", want: []string{"stable=v1.30.4+k3s1"}},
+		{name: "YAML documents", url: "test://channels.yaml", config: "k3s:\n  channels: []\n---\nk3s:\n  channels:\n  - name: stable\n    latest: v1.30.4+k3s1\n", subKey: "k3s", want: []string{"stable=v1.30.4+k3s1"}},
+		{name: "whole config", url: "test://channels.yaml", config: "channels:\n- name: stable\n  latest: v1.30.4+k3s1\n", want: []string{"stable=v1.30.4+k3s1"}},
+		{name: "corrupt", url: "test://channels.yaml", config: testConfig, subKey: "k3s", corrupt: true, wantErr: "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := newTestSource(tt.url, tt.config)
+			live := newConfig(tt.subKey, []Source{source}, Options{CacheDir: dir})
+			if _, err := live.loadConfig(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if tt.corrupt {
+				if err := os.WriteFile(live.cachePath(), []byte("{"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cached := newConfig(tt.subKey, []Source{source}, Options{CacheDir: dir})
+			err := cached.loadCache(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := channelNames(cached); !slices.Equal(got, tt.want) {
+				t.Errorf("served cached channels %v, want %v", got, tt.want)
+			}
+			status := cached.Status()
+			if !status.Stale || status.Source != tt.url || status.Digest != live.Status().Digest {
+				t.Errorf("cached status %+v, live status %+v", status, live.Status())
+			}
+		})
+	}
+}
+
+func TestLoadCacheWithoutDirectory(t *testing.T) {
+	c := newConfig("k3s", []Source{newTestSource("test://channels.yaml", testConfig)}, Options{})
+	if err := c.loadCache(context.Background()); err == nil || !strings.Contains(err.Error(), "no cache directory") {
+		t.Fatalf("expected an error without a cache directory, got %v", err)
+	}
+}
@@ -1,10 +1,18 @@
 package config
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,6 +29,16 @@ type Config struct {
 	channelServerVersion string
 	appName              string
 	urls                 []Source
+	cacheDir             string
+	verifier             *Verifier
+	pins                 map[string]DigestPin
+	overlays             []Source
+	includeOrigins       []string
+	validation           ValidationMode
+	variables            Variables
+	// keys is the key discovery the config of a discovered key is loaded
+	// from, instead of fetching its sources itself.
+	keys *KeyWatcher
 
 	url               string
 	ghToken           string
@@ -29,123 +47,460 @@ type Config struct {
 	channelsConfig    *model.ChannelsConfig
 	releasesConfig    *model.ReleasesConfig
 	appDefaultsConfig *model.AppDefaultsConfig
+	stale             bool
+	loadedAt          time.Time
+	source            string
+	revision          string
+	servedDigest      string
+	files             *sourceFiles
+	health            []SourceHealth
+
+	// State of the last applied content, used to skip unchanged reloads.
+	// Only accessed while holding refreshMu.
+	meta       FetchMeta
+	digest     string
+	content    []byte
+	included   int
+	references []string
+	// values is the fingerprint of the values references resolved to.
+	values     string
+	unresolved *model.ChannelsConfig
+	ghReleases []string
+	ghETag     string
 }
 
-type Wait interface {
-	Wait(ctx context.Context) bool
+// Options configure how a Config loads and serves channel configuration.
+type Options struct {
+	ChannelServerVersion string
+	AppName              string
+	GitHubToken          string
+	// CacheDir is a directory the last successfully applied configuration
+	// is written to, to be served when no source can be loaded at startup.
+	CacheDir string
+	// Verifier, if set, must accept the detached signature of content
+	// before it is applied.
+	Verifier *Verifier
+	// Pins are the digests the content of sources must have, by the URL
+	// of the source.
+	Pins map[string]DigestPin
+	// Overlays are applied in order to the content of the source that is
+	// loaded, before it is parsed.
+	Overlays []Source
+	// Validation is how a config that does not match the schema is handled,
+	// ValidationStrict if empty.
+	Validation ValidationMode
+	// IncludeOrigins are the origins, such as https://config.example.com,
+	// that files can be included from in addition to the origin of the
+	// including file.
+	IncludeOrigins []string
+	// Variables configure the references to the environment and to files
+	// substituted in the config.
+	Variables Variables
 }
 
-type Source interface {
-	URL() string
-}
-
-type DurationWait struct {
-	Duration time.Duration
+func NewConfig(ctx context.Context, subKey string, wait Wait, channelServerVersion string, appName string, ghToken string, urls []Source) *Config {
+	return NewConfigWithOptions(ctx, subKey, wait, urls, Options{
+		ChannelServerVersion: channelServerVersion,
+		AppName:              appName,
+		GitHubToken:          ghToken,
+	})
 }
 
-func (d *DurationWait) Wait(ctx context.Context) bool {
-	select {
-	case <-ctx.Done():
-		return false
-	case <-time.After(d.Duration):
-		return true
+// NewConfigWithOptions loads the configuration for subKey from urls and
+// reloads it whenever wait returns. If the initial load fails the cached
+// configuration from opts.CacheDir is served as stale instead; without one
+// the process exits.
+func NewConfigWithOptions(ctx context.Context, subKey string, wait Wait, urls []Source, opts Options) *Config {
+	c, err := StartConfig(ctx, subKey, wait, urls, opts)
+	if err != nil {
+		logrus.Fatal(err)
 	}
+	return c
 }
 
-type StringSource string
-
-func (s StringSource) URL() string {
-	return string(s)
-}
-
-func NewConfig(ctx context.Context, subKey string, wait Wait, channelServerVersion string, appName string, ghToken string, urls []Source) *Config {
-	c := &Config{
-		subKey:               subKey,
-		channelServerVersion: channelServerVersion,
-		appName:              appName,
-		urls:                 urls,
-
-		ghToken:           ghToken,
-		channelsConfig:    &model.ChannelsConfig{},
-		releasesConfig:    &model.ReleasesConfig{},
-		appDefaultsConfig: &model.AppDefaultsConfig{},
+// StartConfig is like NewConfigWithOptions, but returns an error instead of
+// exiting if neither the initial load nor the cache succeed. Reloading
+// stops, and the sources are closed, once ctx is done.
+func StartConfig(ctx context.Context, subKey string, wait Wait, urls []Source, opts Options) (*Config, error) {
+	c := newConfig(subKey, urls, opts)
+	stop := context.AfterFunc(ctx, c.close)
+
+	logrus.Infof("Loading configuration from %v", sourceURLs(urls))
+	err := c.LoadConfig(ctx)
+	if resultWait, ok := wait.(ResultWait); ok {
+		resultWait.Result(err)
 	}
-
-	logrus.Infof("Loading configuration from %v", urls)
-	if err := c.LoadConfig(ctx); err != nil {
-		logrus.Fatalf("Failed to load initial config for %s: %v", subKey, err)
+	if err != nil {
+		if cacheErr := c.loadCache(ctx); cacheErr != nil {
+			if stop() {
+				c.close()
+			}
+			return nil, fmt.Errorf("failed to load initial config for %s: %w (cache: %v)", subKey, err, cacheErr)
+		}
+		logrus.Warnf("Failed to load initial config for %s, serving stale configuration cached at %s: %v", subKey, c.Status().LoadedAt.Format(time.RFC3339), err)
+	} else {
+		status := c.Status()
+		logrus.Infof("Loaded initial configuration for %s from %s (%s)", subKey, status.Source, status.Digest)
 	}
 
-	logrus.Infof("Loaded initial configuration for %s", subKey)
-
 	if wait != nil {
+		watched := c.watch(ctx)
 		go func() {
-			for wait.Wait(ctx) {
-				if err := c.LoadConfig(ctx); err != nil {
-					logrus.Errorf("Failed to reload configuration for %s: %v", subKey, err)
-				} else {
-					logrus.Infof("Reloaded configuration for %s", subKey)
+			for next(ctx, wait, watched) {
+				changed, err := c.loadConfig(ctx)
+				if resultWait, ok := wait.(ResultWait); ok {
+					resultWait.Result(err)
+				}
+				if err != nil {
+					if c.Status().Stale {
+						logrus.Errorf("Failed to reload configuration for %s, still serving stale cached configuration: %v", subKey, err)
+					} else {
+						logrus.Errorf("Failed to reload configuration for %s: %v", subKey, err)
+					}
+				} else if changed {
+					status := c.Status()
+					logrus.Infof("Reloaded configuration for %s from %s (%s)", subKey, status.Source, status.Digest)
 				}
 			}
 		}()
 	}
 
-	return c
+	return c, nil
+}
+
+// close closes the sources that hold resources between loads, such as the
+// repositories git sources fetch into.
+func (c *Config) close() {
+	for _, source := range append(slices.Clip(c.urls), c.overlays...) {
+		if closer, ok := source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logrus.Warnf("Failed to close %s: %v", source.URL(), err)
+			}
+		}
+	}
+}
+
+// watch starts watching all sources that support it, returning a channel
+// that receives a value when any of them changed.
+func (c *Config) watch(ctx context.Context) <-chan struct{} {
+	watched := make(chan struct{}, 1)
+	for _, url := range c.urls {
+		source, ok := url.(WatchableSource)
+		if !ok {
+			continue
+		}
+		err := source.Watch(ctx, func() {
+			logrus.Infof("Detected change to %s, reloading configuration for %s", source.URL(), c.subKey)
+			select {
+			case watched <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			logrus.Warnf("Failed to watch %s, changes will be picked up on the next refresh: %v", source.URL(), err)
+		}
+	}
+	return watched
+}
+
+// next blocks until wait returns or a watched source changed, returning
+// false once ctx is done.
+func next(ctx context.Context, wait Wait, watched <-chan struct{}) bool {
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- wait.Wait(waitCtx)
+	}()
+
+	select {
+	case ok := <-done:
+		return ok
+	case <-watched:
+		return ctx.Err() == nil
+	}
 }
 
 func NewConfigNoLoad(ctx context.Context, subKey string, channelServerVersion string, appName string, ghToken string, urls []Source) *Config {
+	return newConfig(subKey, urls, Options{
+		ChannelServerVersion: channelServerVersion,
+		AppName:              appName,
+		GitHubToken:          ghToken,
+	})
+}
+
+func newConfig(subKey string, urls []Source, opts Options) *Config {
 	c := &Config{
 		subKey:               subKey,
-		channelServerVersion: channelServerVersion,
-		appName:              appName,
+		channelServerVersion: opts.ChannelServerVersion,
+		appName:              opts.AppName,
 		urls:                 urls,
-
-		ghToken:           ghToken,
+		cacheDir:             opts.CacheDir,
+		verifier:             opts.Verifier,
+		pins:                 opts.Pins,
+		overlays:             opts.Overlays,
+		validation:           opts.Validation,
+		includeOrigins:       opts.IncludeOrigins,
+		variables:            opts.Variables,
+
+		ghToken:           opts.GitHubToken,
 		channelsConfig:    &model.ChannelsConfig{},
 		releasesConfig:    &model.ReleasesConfig{},
 		appDefaultsConfig: &model.AppDefaultsConfig{},
 	}
 
+	for _, url := range urls {
+		if source, ok := url.(*GitHubSource); ok && source.NewClient == nil {
+			source.NewClient = c.sourceGHClient
+		}
+	}
+
 	return c
 }
 
 // Reload the configuration from the source urls. Concurrent loads will
 // not block and immediately return an error.
 func (c *Config) LoadConfig(ctx context.Context) error {
+	_, err := c.loadConfig(ctx)
+	return err
+}
+
+// loadConfig reloads the configuration, reporting whether anything changed.
+// Content the source reports as not modified, or that hashes the same as the
+// applied content, is not parsed again; only the GitHub releases are checked
+// for changes, with a conditional request. Included files and overlays are
+// always fetched again, as they may have changed.
+func (c *Config) loadConfig(ctx context.Context) (bool, error) {
 	locked := c.refreshMu.TryLock()
 	if !locked {
-		return errors.New("configuration is already being loaded")
+		return false, errors.New("configuration is already being loaded")
 	}
 	defer c.refreshMu.Unlock()
 
-	content, index, err := getURLs(ctx, c.urls...)
+	// The config of a discovered key is loaded from the content key
+	// discovery loaded for every key.
+	var shared *loaded
+	if c.keys != nil {
+		shared = c.keys.latest()
+		if shared == nil {
+			return false, errors.New("config keys have not been loaded")
+		}
+	}
+	content, meta, index, err := c.fetch(ctx, shared)
+	if errors.Is(err, ErrNotModified) {
+		if c.included == 0 && len(c.overlays) == 0 && len(c.references) == 0 {
+			logrus.Infof("Configuration for %s at %s is not modified, skipping reload", c.subKey, c.urls[index].URL())
+			return c.refreshGHReleases(ctx)
+		}
+		content, meta = c.content, c.meta
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get content from any source: %w", err)
+	}
+
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+	if c.included == 0 && len(c.overlays) == 0 && digest == c.digest && !c.variablesChanged() {
+		return c.skipUnchanged(ctx, index, meta, content, c.digest)
+	}
+
+	// The content is parsed once, into the generic form that the includes,
+	// overlays and views below all work from. YAML with several documents
+	// is assembled like content with includes, as they are merged.
+	source := c.urls[index].URL()
+	format := DetectFormat(source, meta.ContentType, content)
+	multiple := format == FormatYAML && multiDocument(content)
+	files := map[string][]byte{source: content}
+	var (
+		doc      interface{}
+		notes    provenanceTable
+		document []byte
+		included int
+	)
+	if shared != nil || len(c.overlays) > 0 || bytes.Contains(content, []byte(includeKey)) || multiple {
+		// The provenance of the items of the source is recorded before
+		// includes are spliced in, so that their paths are those in the
+		// source.
+		if shared != nil {
+			doc = deepCopy(shared.doc)
+		} else if doc, err = parseSource(content, format, ""); err != nil {
+			return false, fmt.Errorf("failed to parse config: %w", err)
+		}
+		var includes map[string][]byte
+		doc, includes, err = resolveIncludes(ctx, c.urls[index], doc, c.subKey, c.includeOrigins, c.checkContent)
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve includes: %w", err)
+		}
+		for url, content := range includes {
+			files[url] = content
+		}
+		included = len(includes)
+		if len(c.overlays) > 0 {
+			var overlays [][]byte
+			if shared != nil {
+				overlays = shared.overlays
+			} else if overlays, err = fetchOverlays(ctx, c.overlays, c.checkContent); err != nil {
+				return false, err
+			}
+			if doc, err = applyOverlays(doc, c.subKey, c.overlays, overlays, files); err != nil {
+				return false, err
+			}
+		}
+		// The provenance is kept apart, so that the digest and the
+		// cache are of the config alone.
+		notes = takeProvenance(doc)
+		if included > 0 || len(c.overlays) > 0 || multiple {
+			if document, err = json.Marshal(doc); err != nil {
+				return false, err
+			}
+			digest = fmt.Sprintf("%x", sha256.Sum256(document))
+		}
+	} else if doc, err = parseConfig(content, format); err != nil {
+		return false, fmt.Errorf("failed to parse config: %w", err)
+	}
+	// Content is cached as written, unless it is TOML, which is cached as
+	// JSON. It is encoded before the document is parsed, as that changes
+	// doc.
+	if document == nil {
+		document = content
+		if format == FormatTOML {
+			if document, err = json.Marshal(doc); err != nil {
+				return false, err
+			}
+		}
+	}
+	if digest == c.digest && !c.variablesChanged() {
+		return c.skipUnchanged(ctx, index, meta, content, c.digest)
+	}
+
+	// References are substituted while parsing the document, so the cache
+	// holds the config as written and values read from files, such as
+	// secrets, are not persisted. The values are fingerprinted, so that a
+	// changed value is applied even if the config is unchanged.
+	vars := c.variables.resolver(slices.Collect(maps.Keys(files))...)
+	parsed, err := parseDocument(doc, notes, c.subKey, newSourceFiles(source, files), vars)
 	if err != nil {
-		return fmt.Errorf("failed to get content from url %s: %w", c.urls[index].URL(), err)
+		return false, fmt.Errorf("failed to get channel config: %w", err)
 	}
+	if err := parsed.Validate(); err != nil {
+		if c.validation != ValidationWarn {
+			return false, fmt.Errorf("invalid config: %w", err)
+		}
+		logrus.Warnf("Configuration for %s does not match the schema, applying it anyway: %v", c.subKey, strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	config := parsed.ChannelsConfig()
+	releases, err := parsed.ReleasesConfig(c.channelServerVersion)
+	if err != nil {
+		return false, fmt.Errorf("failed to get release config: %w", err)
+	}
+	appDefaultsConfig := parsed.AppDefaultsConfig(c.appName)
 
-	config, err := GetChannelsConfig(ctx, content, c.subKey)
+	err = c.setConfig(ctx, c.channelServerVersion, config, releases, appDefaultsConfig)
 	if err != nil {
-		return fmt.Errorf("failed to get channel config: %w", err)
+		return false, fmt.Errorf("failed to set config: %w", err)
+	}
+
+	c.setSource(source, meta.ETag, digest)
+	c.Lock()
+	c.files = parsed.files
+	c.Unlock()
+	c.meta = meta
+	c.digest = digest
+	c.references = vars.references()
+	c.values = vars.fingerprint()
+	c.content = content
+	c.included = included
+	c.writeCache(document)
+
+	return true, nil
+}
+
+// fetch returns the content of the first source that can be fetched, or
+// the content shared loaded for every key if set.
+func (c *Config) fetch(ctx context.Context, shared *loaded) ([]byte, FetchMeta, int, error) {
+	if shared != nil {
+		return shared.content, shared.meta, shared.index, nil
+	}
+	return getURLs(ctx, c.Status().Source, c.meta, c.recordFetch, c.checkContent, c.urls...)
+}
+
+// variablesChanged reports whether the references of the applied config
+// resolve to other values than they did when it was applied. A reference
+// that no longer resolves is a change, so that the config is loaded again
+// and fails.
+func (c *Config) variablesChanged() bool {
+	if len(c.references) == 0 {
+		return false
+	}
+	vars := c.variables.resolver()
+	for _, name := range c.references {
+		if _, err := vars.lookup(name); err != nil {
+			return true
+		}
 	}
+	return vars.fingerprint() != c.values
+}
+
+// skipUnchanged records that the content fetched from the source at index
+// hashes the same as the applied content, and only checks the GitHub
+// releases for changes.
+func (c *Config) skipUnchanged(ctx context.Context, index int, meta FetchMeta, content []byte, digest string) (bool, error) {
+	logrus.Infof("Configuration for %s at %s is unchanged (sha256:%s), skipping reload", c.subKey, c.urls[index].URL(), digest)
+	c.setSource(c.urls[index].URL(), meta.ETag, digest)
+	c.meta = meta
+	c.content = content
+	return c.refreshGHReleases(ctx)
+}
+
+// checkContent verifies content fetched from source against its pinned
+// digest and signature before it is applied. Both are checked even for
+// content identical to the applied content, as a digest fetched from a URL
+// may have moved on from it, and its signature may have been replaced.
+func (c *Config) checkContent(ctx context.Context, source Source, content []byte) error {
+	if pin, ok := c.pins[source.URL()]; ok {
+		if err := pin.check(ctx, content); err != nil {
+			return err
+		}
+	}
+	if c.verifier == nil {
+		return nil
+	}
+	return c.verifier.verifySource(ctx, source, content)
+}
 
-	releases, err := GetReleasesConfig(content, c.channelServerVersion, c.subKey)
+// refreshGHReleases re-resolves the channels of the applied configuration
+// if the GitHub releases changed since they were last listed.
+func (c *Config) refreshGHReleases(ctx context.Context) (bool, error) {
+	if c.unresolved == nil || c.unresolved.GitHub == nil {
+		return false, nil
+	}
+
+	gh, err := c.ghClient(c.unresolved)
 	if err != nil {
-		return fmt.Errorf("failed to get release config: %w", err)
+		return false, err
 	}
 
-	appDefaultsConfig, err := GetAppDefaultsConfig(content, c.subKey, c.appName)
+	ghReleases, etag, err := getGHReleases(ctx, gh, c.unresolved.GitHub.Owner, c.unresolved.GitHub.Repo, c.ghETag)
+	if errors.Is(err, ErrNotModified) {
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get app default config: %w", err)
+		return false, fmt.Errorf("failed to refresh GitHub releases: %w", err)
 	}
 
-	err = c.setConfig(ctx, c.channelServerVersion, config, releases, appDefaultsConfig)
+	config, err := resolveChannels(ghReleases, c.unresolved)
 	if err != nil {
-		return fmt.Errorf("failed to set config: %w", err)
+		return false, err
 	}
 
-	c.urls = c.urls[:index+1]
+	c.Lock()
+	c.channelsConfig = config
+	c.Unlock()
+	c.ghReleases = ghReleases
+	c.ghETag = etag
+	c.writeCache(nil)
 
-	return nil
+	logrus.Infof("GitHub releases for %s/%s changed, resolved channels again for %s", c.unresolved.GitHub.Owner, c.unresolved.GitHub.Repo, c.subKey)
+	return true, nil
 }
 
 func (c *Config) ghClient(config *model.ChannelsConfig) (*github.Client, error) {
@@ -154,48 +509,89 @@ func (c *Config) ghClient(config *model.ChannelsConfig) (*github.Client, error)
 	}
 
 	if c.gh == nil || c.url != config.GitHub.APIURL {
-		client := github.NewClient(nil)
-		if c.ghToken != "" {
-			client = client.WithAuthToken(c.ghToken)
-		}
-		if config.GitHub.APIURL != "" {
-			return client.WithEnterpriseURLs(config.GitHub.APIURL, config.GitHub.APIURL)
-		}
-		return client, nil
+		return newGHClient(nil, c.ghToken, config.GitHub.APIURL)
 	}
 	return c.gh, nil
 }
 
-func (c *Config) setConfig(ctx context.Context, channelServerVersion string, config *model.ChannelsConfig, releases *model.ReleasesConfig, appDefaultsConfig *model.AppDefaultsConfig) error {
-	gh, err := c.ghClient(config)
-	if err != nil {
-		return err
+// sourceGHClient returns a client for GitHub sources. Without an explicit
+// apiURL the API URL of the served configuration is used, so sources on a
+// GitHub Enterprise instance only need it configured once.
+func (c *Config) sourceGHClient(client *http.Client, token, apiURL string) (*github.Client, error) {
+	if token == "" {
+		token = c.ghToken
+	}
+	if apiURL == "" {
+		c.Lock()
+		apiURL = c.url
+		c.Unlock()
 	}
+	return newGHClient(client, token, apiURL)
+}
 
-	redirect, err := url.Parse(config.RedirectBase)
+// newGHClient returns a GitHub client making its requests with client, or
+// with a client with the default timeout if nil, so that a request cannot
+// hang a reload.
+func newGHClient(client *http.Client, token, apiURL string) (*github.Client, error) {
+	if client == nil {
+		client = httpClient
+	}
+	gh := github.NewClient(client)
+	if token != "" {
+		gh = gh.WithAuthToken(token)
+	}
+	if apiURL != "" {
+		return gh.WithEnterpriseURLs(apiURL, apiURL)
+	}
+	return gh, nil
+}
+
+func (c *Config) setConfig(ctx context.Context, channelServerVersion string, config *model.ChannelsConfig, releases *model.ReleasesConfig, appDefaultsConfig *model.AppDefaultsConfig) error {
+	gh, err := c.ghClient(config)
 	if err != nil {
 		return err
 	}
 
-	var ghReleases []string
+	var (
+		ghReleases []string
+		ghETag     string
+	)
 	if gh != nil {
-		ghReleases, err = GetGHReleases(ctx, gh, config.GitHub.Owner, config.GitHub.Repo)
+		ghReleases, ghETag, err = getGHReleases(ctx, gh, config.GitHub.Owner, config.GitHub.Repo, "")
 		if err != nil {
 			return err
 		}
 	}
 
-	if err := resolveChannels(ghReleases, config); err != nil {
+	return c.applyConfig(gh, config, releases, appDefaultsConfig, ghReleases, ghETag, false, time.Now())
+}
+
+// applyConfig resolves the channels of config from ghReleases and makes the
+// result the served configuration.
+func (c *Config) applyConfig(gh *github.Client, config *model.ChannelsConfig, releases *model.ReleasesConfig, appDefaultsConfig *model.AppDefaultsConfig, ghReleases []string, ghETag string, stale bool, loadedAt time.Time) error {
+	redirect, err := url.Parse(config.RedirectBase)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := resolveChannels(ghReleases, config)
+	if err != nil {
 		return err
 	}
 
+	c.unresolved = config
+	c.ghReleases = ghReleases
+	c.ghETag = ghETag
+
 	c.Lock()
 	defer c.Unlock()
 	c.gh = gh
-	c.channelsConfig = config
+	c.channelsConfig = resolved
 	c.redirect = redirect
 	c.releasesConfig = releases
 	c.appDefaultsConfig = appDefaultsConfig
+	c.stale = stale
+	c.loadedAt = loadedAt
 	if config.GitHub != nil {
 		c.url = config.GitHub.APIURL
 	}
@@ -203,8 +599,12 @@ func (c *Config) setConfig(ctx context.Context, channelServerVersion string, con
 	return nil
 }
 
-func resolveChannels(releases []string, config *model.ChannelsConfig) error {
-	for i, channel := range config.Channels {
+// resolveChannels returns a copy of config with the latest release of each
+// channel selected by regexp resolved from releases.
+func resolveChannels(releases []string, config *model.ChannelsConfig) (*model.ChannelsConfig, error) {
+	resolved := *config
+	resolved.Channels = append([]model.Channel(nil), config.Channels...)
+	for i, channel := range resolved.Channels {
 		if channel.Latest != "" {
 			continue
 		}
@@ -214,12 +614,41 @@ func resolveChannels(releases []string, config *model.ChannelsConfig) error {
 
 		release, err := Latest(releases, channel.LatestRegexp, channel.ExcludeRegexp)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		config.Channels[i].Latest = release
+		resolved.Channels[i].Latest = release
 	}
 
-	return nil
+	return &resolved, nil
+}
+
+// Status describes the configuration currently served by a Config.
+type Status struct {
+	// Stale is set while the configuration is served from the cache
+	// because no source could be loaded.
+	Stale bool `json:"stale,omitempty"`
+	// LoadedAt is when the served configuration was loaded from its source.
+	LoadedAt time.Time `json:"loadedAt"`
+	// Source is the URL of the source the served configuration came from.
+	Source string `json:"source,omitempty"`
+	// Revision identifies the served content within its source, such as a
+	// commit SHA or an ETag, if the source reports one.
+	Revision string `json:"revision,omitempty"`
+	// Digest is the SHA-256 digest of the served content, as sha256:<hex>,
+	// before references to variables are substituted.
+	Digest string `json:"digest,omitempty"`
+}
+
+func (c *Config) Status() Status {
+	c.Lock()
+	defer c.Unlock()
+	return Status{
+		Stale:    c.stale,
+		LoadedAt: c.loadedAt,
+		Source:   c.source,
+		Revision: c.revision,
+		Digest:   c.servedDigest,
+	}
 }
 
 func (c *Config) ChannelsConfig() *model.ChannelsConfig {
@@ -240,6 +669,16 @@ func (c *Config) AppDefaultsConfig() *model.AppDefaultsConfig {
 	return c.appDefaultsConfig
 }
 
+// Provenance returns p, the provenance of a served item, with the line the
+// item was defined at looked up in the files the configuration was loaded
+// from.
+func (c *Config) Provenance(p *model.Provenance) *model.Provenance {
+	c.Lock()
+	files := c.files
+	c.Unlock()
+	return files.withLine(p)
+}
+
 func (c *Config) Redirect(id string) (string, error) {
 	for _, channel := range c.channelsConfig.Channels {
 		if channel.Name == id && channel.Latest != "" {
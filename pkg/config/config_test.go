@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// testSource is a Source serving content from memory, counting fetches.
+type testSource struct {
+	url string
+
+	mu      sync.Mutex
+	content []byte
+	err     error
+	fetches int
+}
+
+func newTestSource(url, content string) *testSource {
+	return &testSource{url: url, content: []byte(content)}
+}
+
+func (s *testSource) URL() string {
+	return s.url
+}
+
+func (s *testSource) Fetch(context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return append([]byte(nil), s.content...), nil
+}
+
+func (s *testSource) set(content string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content = []byte(content)
+	s.err = err
+}
+
+func (s *testSource) fetched() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+const testConfig = `k3s:
+  channels:
+  - name: stable
+    latest: v1.30.4+k3s1
+  - name: testing
+    latest: v1.31.0+k3s1
+`
+
+func channelNames(c *Config) []string {
+	var names []string
+	for _, channel := range c.ChannelsConfig().Channels {
+		names = append(names, channel.Name+"="+channel.Latest)
+	}
+	return names
+}
+
+func TestLoadConfigSkipsUnchanged(t *testing.T) {
+	source := newTestSource("test://channels.yaml", testConfig)
+	c := newConfig("k3s", []Source{source}, Options{})
+
+	tests := []struct {
+		name        string
+		content     string
+		wantChanged bool
+		wantErr     bool
+	}{
+		{name: "initial load", content: testConfig, wantChanged: true},
+		{name: "same content", content: testConfig, wantChanged: false},
+		{name: "changed content", content: testConfig + "  - name: latest\n    latest: v1.32.0+k3s1\n", wantChanged: true},
+		{name: "invalid content", content: "k3s: [", wantErr: true},
+		{name: "back to the applied content", content: testConfig + "  - name: latest\n    latest: v1.32.0+k3s1\n", wantChanged: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source.set(tt.content, nil)
+			changed, err := c.loadConfig(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+	if got := channelNames(c); len(got) != 3 {
+		t.Errorf("served channels %v, want 3", got)
+	}
+}
+
+func TestStartConfigFallsBackToCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newTestSource("test://channels.yaml", testConfig)
+
+	live, err := StartConfig(ctx, "k3s", nil, []Source{source}, Options{CacheDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live.Status().Stale {
+		t.Fatal("live config reported as stale")
+	}
+
+	source.set("", errors.New("unavailable"))
+	cached, err := StartConfig(ctx, "k3s", nil, []Source{source}, Options{CacheDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := cached.Status()
+	if !status.Stale || status.Source != source.URL() || status.Digest != live.Status().Digest {
+		t.Errorf("cached status %+v, live status %+v", status, live.Status())
+	}
+	if got, want := channelNames(cached), channelNames(live); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("cached channels %v, want %v", got, want)
+	}
+
+	// Without a cache the failure is returned.
+	if _, err := StartConfig(ctx, "k3s", nil, []Source{source}, Options{CacheDir: t.TempDir()}); err == nil {
+		t.Error("expected an error without a cached config")
+	}
+}
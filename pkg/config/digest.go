@@ -0,0 +1,57 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// DigestPin pins the content of a source to a SHA-256 digest.
+type DigestPin struct {
+	// Digest is the expected digest, as sha256:<hex>.
+	Digest string
+	// Source is read for the expected digest if Digest is not set. It holds
+	// the digest as sha256:<hex>, as plain hex, or in the output format of
+	// sha256sum.
+	Source Source
+}
+
+// ParseDigest normalizes a SHA-256 digest given as sha256:<hex>, as plain
+// hex, or as a line of sha256sum output to the sha256:<hex> form.
+func ParseDigest(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty digest")
+	}
+	digest := strings.ToLower(strings.TrimPrefix(fields[0], "sha256:"))
+	if !sha256Hex.MatchString(digest) {
+		return "", fmt.Errorf("invalid SHA-256 digest %q", fields[0])
+	}
+	return "sha256:" + digest, nil
+}
+
+// check verifies that content has the pinned digest.
+func (p *DigestPin) check(ctx context.Context, content []byte) error {
+	expected := p.Digest
+	if expected == "" && p.Source != nil {
+		data, err := p.Source.Fetch(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get pinned digest from %s: %w", p.Source.URL(), err)
+		}
+		expected = string(bytes.TrimSpace(data))
+	}
+
+	expected, err := ParseDigest(expected)
+	if err != nil {
+		return err
+	}
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(content)); actual != expected {
+		return fmt.Errorf("content digest %s does not match pinned digest %s", actual, expected)
+	}
+	return nil
+}
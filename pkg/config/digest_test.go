@@ -0,0 +1,80 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseDigest(t *testing.T) {
+	const hex = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{name: "prefixed", value: "sha256:" + hex, want: "sha256:" + hex},
+		{name: "plain hex", value: hex, want: "sha256:" + hex},
+		{name: "upper case", value: strings.ToUpper(hex), want: "sha256:" + hex},
+		{name: "sha256sum output", value: hex + "  channels.yaml\n", want: "sha256:" + hex},
+		{name: "empty", value: " \n", wantErr: "empty digest"},
+		{name: "too short", value: "sha256:" + hex[:63], wantErr: "invalid SHA-256 digest"},
+		{name: "other algorithm", value: "sha512:" + hex, wantErr: "invalid SHA-256 digest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDigest(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestPinCheck(t *testing.T) {
+	content := []byte(testConfig)
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+	unavailable := newTestSource("test://channels.yaml.sha256", "")
+	unavailable.set("", errors.New("unavailable"))
+
+	tests := []struct {
+		name    string
+		pin     DigestPin
+		wantErr string
+	}{
+		{name: "digest", pin: DigestPin{Digest: "sha256:" + digest}},
+		{name: "digest from a source", pin: DigestPin{Source: newTestSource("test://channels.yaml.sha256", digest+"  channels.yaml\n")}},
+		{name: "digest takes precedence", pin: DigestPin{Digest: digest, Source: unavailable}},
+		{name: "mismatch", pin: DigestPin{Digest: "sha256:" + strings.Repeat("0", 64)}, wantErr: "does not match pinned digest"},
+		{name: "mismatch from a source", pin: DigestPin{Source: newTestSource("test://channels.yaml.sha256", strings.Repeat("0", 64))}, wantErr: "does not match pinned digest"},
+		{name: "source unavailable", pin: DigestPin{Source: unavailable}, wantErr: "failed to get pinned digest from test://channels.yaml.sha256"},
+		{name: "invalid digest", pin: DigestPin{Digest: "latest"}, wantErr: "invalid SHA-256 digest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pin.check(context.Background(), content)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
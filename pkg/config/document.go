@@ -0,0 +1,214 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/blang/semver"
+	"github.com/rancher/channelserver/pkg/model"
+)
+
+// Document is the config of a subkey, parsed once. The channels, releases
+// and app defaults that are served are all derived from it.
+type Document struct {
+	Channels     []model.Channel    `json:"channels,omitempty"`
+	GitHub       *model.GitHub      `json:"github,omitempty"`
+	RedirectBase string             `json:"redirectBase,omitempty"`
+	Releases     []model.Release    `json:"releases,omitempty"`
+	AppDefaults  []model.AppDefault `json:"appDefaults,omitempty"`
+	// Extends is the subkey the config inherits from. It is resolved when
+	// the document is parsed.
+	Extends string `json:"extends,omitempty"`
+
+	subKey string
+	// config is the generic form of the config the document was decoded
+	// from, with the provenance recorded for its items.
+	config interface{}
+	files  *sourceFiles
+}
+
+// errKeyNotFound is returned parsing the document of a subkey that is not
+// in the config.
+var errKeyNotFound = errors.New("failed to find key")
+
+// ParseDocument parses the config for subKey, or the whole content if
+// subKey is empty, with the subkeys it extends merged into it and
+// references to variables substituted as configured by the zero Variables.
+// The format of content, YAML, JSON or TOML, is detected from the content
+// itself.
+func ParseDocument(content []byte, subKey string) (*Document, error) {
+	doc, err := parseConfig(content, DetectFormat("", "", content))
+	if err != nil {
+		return nil, err
+	}
+	return parseDocument(doc, nil, subKey, nil, Variables{}.resolver())
+}
+
+// parseDocument derives the document for subKey from doc, the generic form
+// of the whole config, which is changed in place: it is migrated from older
+// versions of the format and references are substituted in it, before the
+// config of subKey is looked up with the subkeys it extends merged in. The
+// provenance of items taken from doc while it was assembled, if any, is in
+// notes, and is located in files, if set. References are resolved by vars.
+func parseDocument(doc interface{}, notes provenanceTable, subKey string, files *sourceFiles, vars *variables) (*Document, error) {
+	version, err := configVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+	if doc, err = migrateVersion(doc, version, subKey != ""); err != nil {
+		return nil, err
+	}
+	if doc, err = vars.substitute(doc, subKey); err != nil {
+		return nil, fmt.Errorf("failed to substitute variables: %w", err)
+	}
+	notes.restore(doc)
+	if subKey == "" {
+		return decodeDocument(doc, "", files)
+	}
+
+	data, _ := doc.(map[string]interface{})
+	if config, _ := data[subKey].(map[string]interface{}); config != nil && config[extendsKey] != nil {
+		// The items of the subkeys extended record where they are
+		// inherited from.
+		annotateFile(data, "")
+	}
+	config, err := lookupSubKey(data, subKey)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("%w %s in config", errKeyNotFound, subKey)
+	}
+	return decodeDocument(config, subKey, files)
+}
+
+// decodeDocument decodes the config of subKey from its generic form. If it
+// does not decode, the problems found validating it are returned instead,
+// as they name the path of each value of the wrong type.
+func decodeDocument(config interface{}, subKey string, files *sourceFiles) (*Document, error) {
+	document := &Document{subKey: subKey, config: config, files: files}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, document); err != nil {
+		if invalid := document.Validate(); invalid != nil {
+			return nil, invalid
+		}
+		return nil, err
+	}
+	document.recordProvenance()
+	return document, nil
+}
+
+// recordProvenance sets the provenance of the channels, releases and app
+// defaults of the document. Items without a recorded provenance were
+// defined in the source, at their position in the config.
+func (d *Document) recordProvenance() {
+	config, _ := d.config.(map[string]interface{})
+	for i := range d.Channels {
+		d.Channels[i].Provenance = d.provenance(config, "channels", i)
+	}
+	for i := range d.Releases {
+		d.Releases[i].Provenance = d.provenance(config, "releases", i)
+	}
+	for i := range d.AppDefaults {
+		d.AppDefaults[i].Provenance = d.provenance(config, "appDefaults", i)
+	}
+}
+
+func (d *Document) provenance(config map[string]interface{}, list string, i int) *model.Provenance {
+	var recorded interface{}
+	if items, _ := config[list].([]interface{}); i < len(items) {
+		item, _ := items[i].(map[string]interface{})
+		recorded = item[provenanceKey]
+	}
+	p := recordedNote(recorded).provenance(d.subKey, fmt.Sprintf("%s[%d]", joinField(d.subKey, list), i))
+	d.files.locate(p)
+	return p
+}
+
+// Validate checks the document, with the subkeys it extends merged in,
+// against the schema of the config format published as model.Schema.
+// Unknown fields and values of the wrong type are reported as a
+// ValidationError each, with the path of the value, joined.
+func (d *Document) Validate() error {
+	if config, ok := d.config.(map[string]interface{}); ok {
+		annotateConfig(config, "", 0, d.subKey)
+	}
+	return validateConfig(d.config, d.subKey, d.locate)
+}
+
+// locate returns where the item with the recorded provenance was defined,
+// with its line if it is known.
+func (d *Document) locate(recorded interface{}) *model.Provenance {
+	n := recordedNote(recorded)
+	if n == nil {
+		return nil
+	}
+	p := n.provenance(d.subKey, "")
+	d.files.locate(p)
+	if p.File == "" {
+		return nil
+	}
+	return d.files.withLine(p)
+}
+
+// ChannelsConfig returns the channels of the document.
+func (d *Document) ChannelsConfig() *model.ChannelsConfig {
+	return &model.ChannelsConfig{
+		Channels:     d.Channels,
+		GitHub:       d.GitHub,
+		RedirectBase: d.RedirectBase,
+	}
+}
+
+// ReleasesConfig returns the releases of the document available to the
+// channel server version, or all releases if it is empty.
+func (d *Document) ReleasesConfig(channelServerVersion string) (*model.ReleasesConfig, error) {
+	// no server version specified, show all releases
+	if channelServerVersion == "" {
+		return &model.ReleasesConfig{Releases: d.Releases}, nil
+	}
+
+	serverVersion, err := semver.ParseTolerant(channelServerVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	availableReleases := &model.ReleasesConfig{}
+	for _, release := range d.Releases {
+		minServerVer, err := semver.ParseTolerant(release.ChannelServerMinVersion)
+		if err != nil {
+			continue
+		}
+
+		maxServerVer, err := semver.ParseTolerant(release.ChannelServerMaxVersion)
+		if err != nil {
+			continue
+		}
+
+		if serverVersion.GE(minServerVer) && serverVersion.LE(maxServerVer) {
+			availableReleases.Releases = append(availableReleases.Releases, release)
+		}
+	}
+	return availableReleases, nil
+}
+
+// AppDefaultsConfig returns the app defaults of appName, or of all apps if
+// it is empty.
+func (d *Document) AppDefaultsConfig(appName string) *model.AppDefaultsConfig {
+	// no app name is specified, return all AppDefaultsConfigs
+	if appName == "" {
+		return &model.AppDefaultsConfig{AppDefaults: d.AppDefaults}
+	}
+	availableConfigs := &model.AppDefaultsConfig{}
+	for _, appDefault := range d.AppDefaults {
+		if appDefault.AppName == appName {
+			availableConfigs.AppDefaults = append(availableConfigs.AppDefaults, appDefault)
+			break
+		}
+	}
+	return availableConfigs
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rancher/channelserver/pkg/model"
+	"github.com/rancher/wrangler/v3/pkg/data/convert"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseDocument(t *testing.T) {
+	const products = `k3s:
+  channels:
+  - name: stable
+    latest: v1.30.4+k3s1
+rke2:
+  extends: k3s
+  channels:
+  - name: latest
+    latest: v1.31.0+rke2r1
+`
+	tests := []struct {
+		name    string
+		content string
+		subKey  string
+		// want are the channels of the document, each with the path and
+		// the subkey it is inherited from, if any, of its provenance.
+		want    []string
+		wantErr string
+	}{
+		{name: "whole config", content: "channels:\n- name: stable\n  latest: v1.30.4+k3s1\n", want: []string{"stable channels[0]"}},
+		{name: "subkey", content: products, subKey: "k3s", want: []string{"stable k3s.channels[0]"}},
+		{name: "extended subkey", content: products, subKey: "rke2", want: []string{"stable k3s.channels[0] k3s", "latest rke2.channels[0]"}},
+		{name: "JSON", content: `{"k3s": {"channels": [{"name": "stable", "latest": "v1.30.4+k3s1"}]}}`, subKey: "k3s", want: []string{"stable k3s.channels[0]"}},
+		{name: "TOML", content: "[[k3s.channels]]\nname = \"stable\"\nlatest = \"v1.30.4+k3s1\"\n", subKey: "k3s", want: []string{"stable k3s.channels[0]"}},
+		{name: "missing subkey", content: products, subKey: "k3k", wantErr: "failed to find key k3k in config"},
+		{name: "subkey not a mapping", content: products + "k3k: []\n", subKey: "k3k", wantErr: "failed to find key k3k in config"},
+		{name: "value of the wrong type", content: "k3s:\n  channels:\n  - name: [stable]\n", subKey: "k3s", wantErr: "k3s.channels[0].name: must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := ParseDocument([]byte(tt.content), tt.subKey)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, channel := range document.ChannelsConfig().Channels {
+				got = append(got, strings.TrimSpace(strings.Join([]string{channel.Name, channel.Provenance.Path, channel.Provenance.InheritedFrom}, " ")))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got channels %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// benchmarkConfig returns a config of products subkeys with releases
+// releases each, like the release files of the products served.
+func benchmarkConfig(products, releases int) []byte {
+	var b strings.Builder
+	for p := 0; p < products; p++ {
+		fmt.Fprintf(&b, "product%d:\n  channels:\n  - name: stable\n    latest: v1.30.%d\n  releases:\n", p, releases-1)
+		for r := 0; r < releases; r++ {
+			fmt.Fprintf(&b, `  - version: v1.30.%d
+    minChannelServerVersion: v2.8.0
+    maxChannelServerVersion: v2.10.99
+    serverArgs:
+      disable:
+        type: array
+        options: [coredns, servicelb, traefik]
+    featureVersions:
+      encryption-key-rotation: 2.0.0
+    charts:
+      traefik:
+        repo: rancher-rke2-charts
+        version: 27.0.201
+`, r)
+		}
+		b.WriteString("  appDefaults:\n  - appName: rancher\n    defaults:\n    - appVersion: '>= 2.9'\n      defaultVersion: v1.30.x\n")
+	}
+	return []byte(b.String())
+}
+
+// baselineViews derives the views of the config for subKey as LoadConfig
+// did before content was parsed once: unmarshaled again for each view and
+// converted with convert.ToObj.
+func baselineViews(content []byte, subKey string) error {
+	for _, view := range []interface{}{&model.ChannelsConfig{}, &model.ReleasesConfig{}, &model.AppDefaultsConfig{}} {
+		var data map[string]interface{}
+		if err := yaml.Unmarshal(content, &data); err != nil {
+			return err
+		}
+		if err := convert.ToObj(data[subKey], view); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func BenchmarkLoadConfig(b *testing.B) {
+	content := benchmarkConfig(4, 2500)
+	b.Run("baseline", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := baselineViews(content, "product0"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parsed once", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			document, err := ParseDocument(content, "product0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			document.ChannelsConfig()
+			if _, err := document.ReleasesConfig("v2.9.0"); err != nil {
+				b.Fatal(err)
+			}
+			document.AppDefaultsConfig("rancher")
+		}
+	})
+	b.Run("load", func(b *testing.B) {
+		source := newTestSource("test://channels.yaml", string(content))
+		b.ReportAllocs()
+		for b.Loop() {
+			c := newConfig("product0", []Source{source}, Options{ChannelServerVersion: "v2.9.0"})
+			if _, err := c.loadConfig(b.Context()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SourceHealth is the outcome of the most recent fetches from a source.
+type SourceHealth struct {
+	URL                 string    `json:"url"`
+	Healthy             bool      `json:"healthy"`
+	LastSuccess         time.Time `json:"lastSuccess,omitzero"`
+	LastFailure         time.Time `json:"lastFailure,omitzero"`
+	LastError           string    `json:"lastError,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures,omitempty"`
+}
+
+// recordFetch updates the health of the source at index after a fetch.
+func (c *Config) recordFetch(index int, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.health == nil {
+		c.health = make([]SourceHealth, len(c.urls))
+		for i, url := range c.urls {
+			c.health[i].URL = url.URL()
+		}
+	}
+
+	health := &c.health[index]
+	if err == nil {
+		if health.ConsecutiveFailures > 0 {
+			logrus.Infof("Source %s for %s recovered after %d failed attempts", health.URL, c.subKey, health.ConsecutiveFailures)
+		}
+		health.Healthy = true
+		health.LastSuccess = time.Now()
+		health.ConsecutiveFailures = 0
+		return
+	}
+
+	logrus.Warnf("Failed to fetch configuration for %s from %s: %v", c.subKey, health.URL, err)
+	health.Healthy = false
+	health.LastFailure = time.Now()
+	health.LastError = err.Error()
+	health.ConsecutiveFailures++
+}
+
+// setSource records url as the source the served configuration came from,
+// with the revision reported by the source and the hex SHA-256 digest of the
+// served content.
+func (c *Config) setSource(url, revision, digest string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.revision = revision
+	c.servedDigest = ""
+	if digest != "" {
+		c.servedDigest = "sha256:" + digest
+	}
+	if c.source == url {
+		return
+	}
+	if c.source != "" {
+		if c.sourceIndex(url) == 0 {
+			logrus.Infof("Configuration for %s returned to primary source %s from %s", c.subKey, url, c.source)
+		} else {
+			logrus.Warnf("Configuration for %s failed over from %s to %s", c.subKey, c.source, url)
+		}
+	}
+	c.source = url
+}
+
+func (c *Config) sourceIndex(url string) int {
+	for i, source := range c.urls {
+		if source.URL() == url {
+			return i
+		}
+	}
+	return -1
+}
+
+// SourceHealth returns the health of each source, in priority order.
+func (c *Config) SourceHealth() []SourceHealth {
+	if c.keys != nil {
+		return c.keys.c.SourceHealth()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	result := make([]SourceHealth, len(c.urls))
+	for i, url := range c.urls {
+		if c.health != nil {
+			result[i] = c.health[i]
+		} else {
+			result[i].URL = url.URL()
+		}
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLoadConfigFailover(t *testing.T) {
+	primary := newTestSource("test://primary/channels.yaml", testConfig)
+	mirror := newTestSource("test://mirror/channels.yaml", testConfig+"  - name: mirror\n    latest: v1.29.0+k3s1\n")
+	c := newConfig("k3s", []Source{primary, mirror}, Options{})
+
+	tests := []struct {
+		name         string
+		primaryErr   error
+		mirrorErr    error
+		wantSource   string
+		wantFailures []int
+		wantErr      bool
+	}{
+		{name: "primary", wantSource: primary.url, wantFailures: []int{0, 0}},
+		{name: "failover", primaryErr: errors.New("down"), wantSource: mirror.url, wantFailures: []int{1, 0}},
+		{name: "all down", primaryErr: errors.New("down"), mirrorErr: errors.New("down"), wantSource: mirror.url, wantFailures: []int{2, 1}, wantErr: true},
+		// The mirror is not tried once the primary recovers.
+		{name: "back to primary", wantSource: primary.url, wantFailures: []int{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary.err, mirror.err = tt.primaryErr, tt.mirrorErr
+			_, err := c.loadConfig(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := c.Status().Source; got != tt.wantSource {
+				t.Errorf("source %s, want %s", got, tt.wantSource)
+			}
+			for i, health := range c.SourceHealth() {
+				if health.ConsecutiveFailures != tt.wantFailures[i] {
+					t.Errorf("source %d failed %d times in a row, want %d", i, health.ConsecutiveFailures, tt.wantFailures[i])
+				}
+			}
+		})
+	}
+
+	health := c.SourceHealth()
+	if !health[0].Healthy || health[0].URL != primary.url || health[0].LastError != "down" {
+		t.Errorf("primary health %+v", health[0])
+	}
+	if health[1].Healthy || health[1].LastSuccess.IsZero() || health[1].LastError != "down" {
+		t.Errorf("mirror health %+v", health[1])
+	}
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yamlv3 "go.yaml.in/yaml/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// Format is the syntax a config file is written in.
+type Format string
+
+const (
+	// FormatYAML is YAML, with one config or several documents that are
+	// merged in order.
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+)
+
+// String returns the name of the format as it is written in messages.
+func (f Format) String() string {
+	return strings.ToUpper(string(f))
+}
+
+// formatsByExtension are the formats of files by their extension.
+var formatsByExtension = map[string]Format{
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".json": FormatJSON,
+	".toml": FormatTOML,
+}
+
+// formatsByMediaType are the formats of content by the media type a source
+// reports for it.
+var formatsByMediaType = map[string]Format{
+	"application/yaml":   FormatYAML,
+	"application/x-yaml": FormatYAML,
+	"text/yaml":          FormatYAML,
+	"text/x-yaml":        FormatYAML,
+	"application/json":   FormatJSON,
+	"text/json":          FormatJSON,
+	"application/toml":   FormatTOML,
+	"text/toml":          FormatTOML,
+	"text/x-toml":        FormatTOML,
+}
+
+// tomlLine matches a table header or a key assignment, one of which starts
+// any TOML config, and neither of which starts a YAML or JSON one.
+var tomlLine = regexp.MustCompile(`^(?:\[\[?[A-Za-z0-9_."' -]+\]\]?|[A-Za-z0-9_"'.$-]+\s*=)`)
+
+// DetectFormat returns the format of content fetched from the URL name, by
+// the extension of name, or else by contentType, the media type the source
+// reported, or else by the content itself. Content that is not recognised,
+// including content starting with { that is not valid JSON, is read as
+// YAML, which JSON is a subset of.
+func DetectFormat(name, contentType string, content []byte) Format {
+	name, _, _ = strings.Cut(name, "?")
+	name, _, _ = strings.Cut(name, "#")
+	if format, ok := formatsByExtension[strings.ToLower(path.Ext(name))]; ok {
+		return format
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if format, ok := formatsByMediaType[mediaType]; ok {
+			return format
+		}
+		switch {
+		case strings.HasSuffix(mediaType, "+json"):
+			return FormatJSON
+		case strings.HasSuffix(mediaType, "+yaml"):
+			return FormatYAML
+		}
+	}
+
+	for rest := content; len(rest) > 0; {
+		var line []byte
+		line, rest, _ = bytes.Cut(rest, []byte("\n"))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		switch {
+		case line[0] == '{':
+			// A YAML flow mapping starts like a JSON object.
+			if json.Valid(content) {
+				return FormatJSON
+			}
+			return FormatYAML
+		case tomlLine.Match(line):
+			return FormatTOML
+		}
+		break
+	}
+	return FormatYAML
+}
+
+// SyntaxError is content that cannot be parsed in the format it is read
+// as. Line is the line of the content the problem was found at, if known.
+type SyntaxError struct {
+	Format  Format
+	Line    int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("invalid %s at line %d: %s", e.Format, e.Line, e.Message)
+	}
+	return fmt.Sprintf("invalid %s: %s", e.Format, e.Message)
+}
+
+var (
+	yamlError = regexp.MustCompile(`^(?:error converting YAML to JSON: )?yaml: (?:line (\d+): )?`)
+	tomlError = regexp.MustCompile(`^toml: (?:line (\d+)[^:]*: )?`)
+)
+
+// syntaxError returns err, returned parsing content in format, as a
+// SyntaxError.
+func syntaxError(format Format, content []byte, err error) error {
+	result := &SyntaxError{Format: format, Message: err.Error()}
+	var (
+		jsonErr  *json.SyntaxError
+		tomlErr  toml.ParseError
+		prefixes *regexp.Regexp
+	)
+	switch {
+	case errors.As(err, &jsonErr):
+		offset := min(int(jsonErr.Offset), len(content))
+		result.Line = bytes.Count(content[:offset], []byte("\n")) + 1
+		return result
+	case errors.As(err, &tomlErr):
+		result.Line = tomlErr.Position.Line
+		if tomlErr.Message != "" {
+			result.Message = tomlErr.Message
+			return result
+		}
+		prefixes = tomlError
+	default:
+		prefixes = yamlError
+	}
+	if match := prefixes.FindStringSubmatch(result.Message); match != nil {
+		if line, err := strconv.Atoi(match[1]); err == nil {
+			result.Line = line
+		}
+		result.Message = result.Message[len(match[0]):]
+	}
+	return result
+}
+
+// toJSON converts content in format to JSON. The documents of YAML content
+// with several are merged.
+func toJSON(content []byte, format Format) ([]byte, error) {
+	switch {
+	case format == FormatJSON:
+		if json.Valid(content) {
+			return content, nil
+		}
+		var doc interface{}
+		return nil, syntaxError(format, content, json.Unmarshal(content, &doc))
+	case format == FormatYAML && !multiDocument(content):
+		data, err := yaml.YAMLToJSON(content)
+		if err != nil {
+			return nil, syntaxError(format, content, err)
+		}
+		return data, nil
+	}
+	doc, err := parseConfig(content, format)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// parseConfig parses content in format into its generic form, with the
+// documents of YAML content with several merged.
+func parseConfig(content []byte, format Format) (interface{}, error) {
+	docs, err := parseDocuments(content, format)
+	if err != nil {
+		return nil, err
+	}
+	return mergeDocuments(docs), nil
+}
+
+// parseSource parses content in format like parseConfig, recording file
+// and the document of each item as its provenance.
+func parseSource(content []byte, format Format, file string) (interface{}, error) {
+	docs, err := parseDocuments(content, format)
+	if err != nil {
+		return nil, err
+	}
+	for i, doc := range docs {
+		annotate(doc, file, i)
+	}
+	return mergeDocuments(docs), nil
+}
+
+// mergeDocuments merges docs in order, each as a strategic merge patch of
+// those before it, so that a later document can add to or override the
+// channels, releases and app defaults of an earlier one. Empty documents
+// are skipped.
+func mergeDocuments(docs []interface{}) interface{} {
+	var doc interface{}
+	for _, next := range docs {
+		switch {
+		case next == nil:
+		case doc == nil:
+			doc = next
+		default:
+			doc = mergePatch(doc, next, "")
+		}
+	}
+	return doc
+}
+
+// multiDocument reports whether YAML content may hold more than one
+// document, as a document start marker follows content other than
+// comments.
+func multiDocument(content []byte) bool {
+	started := false
+	for len(content) > 0 {
+		var line []byte
+		line, content, _ = bytes.Cut(content, []byte("\n"))
+		if isDocumentStart(line) {
+			if started {
+				return true
+			}
+			continue
+		}
+		if !started {
+			line = bytes.TrimSpace(line)
+			started = len(line) > 0 && line[0] != '#'
+			continue
+		}
+		// Only the lines that may be markers are looked at once a
+		// document has started.
+		next := bytes.Index(content, []byte("\n---"))
+		if next < 0 {
+			line, _, _ = bytes.Cut(content, []byte("\n"))
+			return isDocumentStart(line)
+		}
+		content = content[next+1:]
+	}
+	return false
+}
+
+// isDocumentStart reports whether line is the marker that starts a YAML
+// document.
+func isDocumentStart(line []byte) bool {
+	rest, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r"), []byte("---"))
+	return ok && (len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t')
+}
+
+// parseDocuments parses the documents of content in format. Only YAML
+// content can hold more than one.
+func parseDocuments(content []byte, format Format) ([]interface{}, error) {
+	switch {
+	case format == FormatTOML:
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(content, &doc); err != nil {
+			return nil, syntaxError(format, content, err)
+		}
+		// Values are converted to the types they have in JSON, such as
+		// times to strings, like those of the other formats.
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		var result interface{}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		return []interface{}{result}, nil
+	case format == FormatYAML && multiDocument(content):
+		return parseYAMLDocuments(content)
+	}
+	data, err := toJSON(content, format)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, syntaxError(format, content, err)
+	}
+	return []interface{}{doc}, nil
+}
+
+// parseYAMLDocuments splits content into its documents, which are each
+// converted like content with a single one, so that values are read the
+// same either way.
+func parseYAMLDocuments(content []byte) ([]interface{}, error) {
+	var docs []interface{}
+	decoder := yamlv3.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yamlv3.Node
+		if err := decoder.Decode(&node); errors.Is(err, io.EOF) {
+			return docs, nil
+		} else if err != nil {
+			return nil, syntaxError(FormatYAML, content, err)
+		}
+		var doc interface{}
+		if len(node.Content) > 0 && node.Content[0].Tag != "!!null" {
+			document, err := yamlv3.Marshal(&node)
+			if err != nil {
+				return nil, err
+			}
+			if err := yaml.Unmarshal(document, &doc); err != nil {
+				// The line is that of the document as written again, so
+				// the document is named instead.
+				invalid := syntaxError(FormatYAML, document, err).(*SyntaxError)
+				invalid.Line = 0
+				invalid.Message = fmt.Sprintf("document %d: %s", len(docs)+1, invalid.Message)
+				return nil, invalid
+			}
+		}
+		docs = append(docs, doc)
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		content     string
+		want        Format
+	}{
+		{name: "YAML extension", url: "https://example.com/channels.yml", content: `{"k3s": {}}`, want: FormatYAML},
+		{name: "JSON extension", url: "file:///etc/channels.json", content: "k3s: {}\n", want: FormatJSON},
+		{name: "TOML extension with a query", url: "https://example.com/channels.toml?ref=main#k3s", want: FormatTOML},
+		{name: "media type", url: "https://example.com/channels", contentType: "application/json; charset=utf-8", want: FormatJSON},
+		{name: "structured media type", url: "https://example.com/channels", contentType: "application/vnd.channels+yaml", content: `{"k3s": {}}`, want: FormatYAML},
+		{name: "unknown media type", contentType: "text/plain", content: `{"k3s": {}}`, want: FormatJSON},
+		{name: "JSON", content: "\n  {\n  \"k3s\": {\"channels\": []}\n}\n", want: FormatJSON},
+		{name: "YAML flow mapping", content: "{k3s: {channels: []}}\n", want: FormatYAML},
+		{name: "JSON after a comment", content: "# channels\n{\"k3s\": {\"channels\": []}}\n", want: FormatYAML},
+		{name: "invalid JSON", content: `{"k3s": {"channels": []}`, want: FormatYAML},
+		{name: "TOML key", content: "# channels\napiVersion = \"v1\"\n", want: FormatTOML},
+		{name: "TOML table", content: "[[k3s.channels]]\nname = \"stable\"\n", want: FormatTOML},
+		{name: "YAML", content: "k3s:\n  channels: []\n", want: FormatYAML},
+		{name: "empty", want: FormatYAML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat(tt.url, tt.contentType, []byte(tt.content)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDocumentFlowMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "empty", content: "{k3s: {channels: []}}", want: nil},
+		{name: "channels", content: "{k3s: {channels: [{name: stable, latest: v1.30.4+k3s1}]}}\n", want: []string{"stable=v1.30.4+k3s1"}},
+		{name: "JSON", content: `{"k3s": {"channels": [{"name": "stable", "latest": "v1.30.4+k3s1"}]}}`, want: []string{"stable=v1.30.4+k3s1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := ParseDocument([]byte(tt.content), "k3s")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, channel := range document.Channels {
+				got = append(got, channel.Name+"="+channel.Latest)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got channels %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
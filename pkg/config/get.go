@@ -2,148 +2,157 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"time"
+	"slices"
+	"strings"
 
-	"github.com/blang/semver"
 	"github.com/google/go-github/v67/github"
 	"github.com/rancher/channelserver/pkg/model"
-	"github.com/rancher/wrangler/v3/pkg/data/convert"
-	"sigs.k8s.io/yaml"
 )
 
-var (
-	httpClient = &http.Client{
-		Timeout: time.Second * 5,
-	}
-)
-
-func getURLs(ctx context.Context, urls ...Source) ([]byte, int, error) {
-	var (
-		bytes []byte
-		err   error
-		index int
-	)
+// getURLs returns the content of the first source, in priority order, that
+// can be fetched and is accepted by check. The validators in meta are only
+// sent to the source whose URL is active, as they describe content
+// previously served by that source. The outcome of every attempted fetch is
+// passed to report. If all sources fail the returned error includes the
+// error of each.
+func getURLs(ctx context.Context, active string, meta FetchMeta, report func(int, error), check func(context.Context, Source, []byte) error, urls ...Source) ([]byte, FetchMeta, int, error) {
+	var errs []error
 	for i, url := range urls {
-		index = i
-		bytes, err = get(ctx, url)
+		var prev FetchMeta
+		if url.URL() == active {
+			prev = meta
+		}
+		bytes, next, err := fetch(ctx, url, prev)
+		if errors.Is(err, ErrNotModified) {
+			report(i, nil)
+			return nil, next, i, err
+		}
+		if err == nil && check != nil {
+			err = check(ctx, url, bytes)
+		}
+		report(i, err)
 		if err == nil {
-			break
+			return bytes, next, i, nil
 		}
+		errs = append(errs, fmt.Errorf("%s: %w", url.URL(), err))
 	}
 
-	return bytes, index, err
+	if len(errs) == 0 {
+		return nil, FetchMeta{}, -1, errors.New("no sources configured")
+	}
+	return nil, FetchMeta{}, -1, errors.Join(errs...)
 }
 
-func get(ctx context.Context, url Source) ([]byte, error) {
-	content, err := os.ReadFile(url.URL())
-	if err == nil {
-		return content, nil
-	}
+// extendsKey is the field of the config of a subkey naming another subkey
+// whose config it inherits.
+const extendsKey = "extends"
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.URL(), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := httpClient.Do(req)
+// GetChannelsConfig returns the channels of the config for subKey. Use
+// ParseDocument to get more than one view of the same content.
+func GetChannelsConfig(ctx context.Context, content []byte, subKey string) (*model.ChannelsConfig, error) {
+	document, err := ParseDocument(content, subKey)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %v", resp.Status)
-	}
-
-	return io.ReadAll(resp.Body)
+	return document.ChannelsConfig(), nil
 }
 
-func GetChannelsConfig(ctx context.Context, content []byte, subKey string) (*model.ChannelsConfig, error) {
-	var (
-		data   = map[string]interface{}{}
-		config = &model.ChannelsConfig{}
-	)
-
-	if subKey == "" {
-		return config, yaml.Unmarshal(content, config)
-	}
+// lookupSubKey returns the config at subKey, with the config of the subkey
+// it extends, if any, merged into it. Channels, releases and appDefaults
+// are merged by name, version and appName, and other fields of subKey
+// override those of the subkey extended. Nil is returned if subKey is not
+// a mapping.
+func lookupSubKey(data map[string]interface{}, subKey string) (map[string]interface{}, error) {
+	return lookupExtended(data, subKey, nil)
+}
 
-	if err := yaml.Unmarshal(content, &data); err != nil {
-		return nil, err
-	}
-	data, _ = data[subKey].(map[string]interface{})
-	if data == nil {
-		return nil, fmt.Errorf("failed to find key %s in config", subKey)
+// extendedKeys returns subKey followed by the subkeys it extends, in order,
+// stopping at the first that is not in data or was already visited.
+func extendedKeys(data map[string]interface{}, subKey string) []string {
+	var keys []string
+	for key := subKey; data[key] != nil && !slices.Contains(keys, key); {
+		keys = append(keys, key)
+		config, _ := data[key].(map[string]interface{})
+		key, _ = config[extendsKey].(string)
 	}
-	return config, convert.ToObj(data, config)
+	return keys
 }
 
-func GetReleasesConfig(content []byte, channelServerVersion, subKey string) (*model.ReleasesConfig, error) {
-	var (
-		allReleases       model.ReleasesConfig
-		availableReleases model.ReleasesConfig
-		data              map[string]interface{}
-	)
-
-	if subKey == "" {
-		if err := yaml.Unmarshal(content, &allReleases); err != nil {
-			return nil, err
-		}
-	} else {
-		if err := yaml.Unmarshal(content, &data); err != nil {
-			return nil, err
-		}
-		data, _ = data[subKey].(map[string]interface{})
-		if err := convert.ToObj(data, &allReleases); err != nil {
-			return nil, err
+func lookupExtended(data map[string]interface{}, subKey string, chain []string) (map[string]interface{}, error) {
+	for _, key := range chain {
+		if key == subKey {
+			return nil, fmt.Errorf("subkeys extend each other in a cycle: %s -> %s", strings.Join(chain, " -> "), subKey)
 		}
 	}
-
-	// no server version specified, show all releases
-	if channelServerVersion == "" {
-		return &allReleases, nil
+	config, _ := data[subKey].(map[string]interface{})
+	if config == nil || config[extendsKey] == nil {
+		return config, nil
 	}
 
-	availableReleases = model.ReleasesConfig{}
-
-	serverVersion, err := semver.ParseTolerant(channelServerVersion)
+	parentKey, ok := config[extendsKey].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s of subkey %s must be a string, found %T", extendsKey, subKey, config[extendsKey])
+	}
+	parent, err := lookupExtended(data, parentKey, append(chain, subKey))
 	if err != nil {
 		return nil, err
 	}
-
-	for _, release := range allReleases.Releases {
-		minServerVer, err := semver.ParseTolerant(release.ChannelServerMinVersion)
-		if err != nil {
-			continue
-		}
-
-		maxServerVer, err := semver.ParseTolerant(release.ChannelServerMaxVersion)
-		if err != nil {
-			continue
-		}
-
-		if serverVersion.GE(minServerVer) && serverVersion.LE(maxServerVer) {
-			availableReleases.Releases = append(availableReleases.Releases, release)
-		}
+	if parent == nil {
+		return nil, fmt.Errorf("subkey %s extends %s, which is not in config", subKey, parentKey)
 	}
+	result, _ := mergePatch(inherited(parent, parentKey), withoutKey(config, extendsKey), "").(map[string]interface{})
+	return result, nil
+}
 
-	return &availableReleases, nil
+// GetReleasesConfig returns the releases of the config for subKey available
+// to the channel server version. A config without subKey has no releases.
+func GetReleasesConfig(content []byte, channelServerVersion, subKey string) (*model.ReleasesConfig, error) {
+	document, err := ParseDocument(content, subKey)
+	if errors.Is(err, errKeyNotFound) {
+		return &model.ReleasesConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return document.ReleasesConfig(channelServerVersion)
 }
 
 func GetGHReleases(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+	releases, _, err := getGHReleases(ctx, client, owner, repo, "")
+	return releases, err
+}
+
+// getGHReleases lists the release tags of a repository. If etag matches the
+// first page of releases ErrNotModified is returned, as new releases are
+// always listed first.
+func getGHReleases(ctx context.Context, client *github.Client, owner, repo, etag string) ([]string, string, error) {
 	var (
-		opt         = &github.ListOptions{}
+		page        = 1
+		firstETag   string
 		allReleases []string
 	)
 
 	for {
-		releases, resp, err := client.Repositories.ListReleases(ctx, owner, repo, opt)
+		req, err := client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/releases?per_page=100&page=%d", owner, repo, page), nil)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		if page == 1 && etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		var releases []*github.RepositoryRelease
+		resp, err := client.Do(ctx, req, &releases)
+		if resp != nil && resp.StatusCode == http.StatusNotModified {
+			return nil, etag, ErrNotModified
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if page == 1 {
+			firstETag = resp.Header.Get("ETag")
 		}
 		for _, release := range releases {
 			if release.GetTagName() != "" && !release.GetPrerelease() {
@@ -153,45 +162,18 @@ func GetGHReleases(ctx context.Context, client *github.Client, owner, repo strin
 		if resp.NextPage == 0 {
 			break
 		}
-		opt.Page = resp.NextPage
+		page = resp.NextPage
 	}
 
-	return allReleases, nil
+	return allReleases, firstETag, nil
 }
 
+// GetAppDefaultsConfig returns the app defaults of appName in the config for
+// subKey.
 func GetAppDefaultsConfig(content []byte, subKey, appName string) (*model.AppDefaultsConfig, error) {
-	var (
-		data             map[string]interface{}
-		allConfigs       model.AppDefaultsConfig
-		availableConfigs model.AppDefaultsConfig
-	)
-
-	if subKey == "" {
-		if err := yaml.Unmarshal(content, &allConfigs); err != nil {
-			return nil, err
-		}
-	} else {
-		if err := yaml.Unmarshal(content, &data); err != nil {
-			return nil, err
-		}
-		subData, ok := data[subKey].(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("content at key %s expected to be map[string]interface{}, found %T instead", subKey, data[subKey])
-		}
-		if err := convert.ToObj(subData, &allConfigs); err != nil {
-			return nil, err
-		}
-	}
-
-	// no app name is specified, return all AppDefaultsConfigs
-	if appName == "" {
-		return &allConfigs, nil
-	}
-	for _, appDefault := range allConfigs.AppDefaults {
-		if appDefault.AppName == appName {
-			availableConfigs.AppDefaults = append(availableConfigs.AppDefaults, appDefault)
-			break
-		}
+	document, err := ParseDocument(content, subKey)
+	if err != nil {
+		return nil, err
 	}
-	return &availableConfigs, nil
+	return document.AppDefaultsConfig(appName), nil
 }
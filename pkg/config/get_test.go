@@ -0,0 +1,185 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGetConfigExtends(t *testing.T) {
+	const base = `k3s:
+  redirectBase: https://github.com/
+  channels:
+  - name: stable
+    latest: v1.30.4+k3s1
+  - name: testing
+    latest: v1.31.0+k3s1
+  releases:
+  - version: v1.30.4+k3s1
+  appDefaults:
+  - appName: rancher
+    defaults:
+    - appVersion: '>= 2.9'
+      defaultVersion: 1.30.x
+`
+	tests := []struct {
+		name         string
+		config       string
+		subKey       string
+		wantChannels string
+		wantReleases string
+		wantApps     string
+		wantErr      string
+	}{
+		{
+			name:         "not extended",
+			config:       base,
+			subKey:       "k3s",
+			wantChannels: `{"channels":[{"name":"stable","latest":"v1.30.4+k3s1"},{"name":"testing","latest":"v1.31.0+k3s1"}],"redirectBase":"https://github.com/"}`,
+			wantReleases: `{"releases":[{"version":"v1.30.4+k3s1"}]}`,
+			wantApps:     `{"appDefaults":[{"appName":"rancher","defaults":[{"appVersion":"\u003e= 2.9","defaultVersion":"1.30.x"}]}]}`,
+		},
+		{
+			name:         "inherited",
+			config:       base + "rke2:\n  extends: k3s\n",
+			subKey:       "rke2",
+			wantChannels: `{"channels":[{"name":"stable","latest":"v1.30.4+k3s1"},{"name":"testing","latest":"v1.31.0+k3s1"}],"redirectBase":"https://github.com/"}`,
+			wantReleases: `{"releases":[{"version":"v1.30.4+k3s1"}]}`,
+			wantApps:     `{"appDefaults":[{"appName":"rancher","defaults":[{"appVersion":"\u003e= 2.9","defaultVersion":"1.30.x"}]}]}`,
+		},
+		{
+			name: "overridden by name, version and appName",
+			config: base + `rke2:
+  extends: k3s
+  redirectBase: https://rke2.example.com/
+  channels:
+  - name: stable
+    latest: v1.30.4+rke2r1
+  - name: latest
+    latest: v1.32.0+rke2r1
+  releases:
+  - version: v1.30.4+rke2r1
+  appDefaults:
+  - appName: rancher
+    defaults:
+    - appVersion: '>= 2.10'
+      defaultVersion: 1.31.x
+`,
+			subKey:       "rke2",
+			wantChannels: `{"channels":[{"name":"stable","latest":"v1.30.4+rke2r1"},{"name":"testing","latest":"v1.31.0+k3s1"},{"name":"latest","latest":"v1.32.0+rke2r1"}],"redirectBase":"https://rke2.example.com/"}`,
+			wantReleases: `{"releases":[{"version":"v1.30.4+k3s1"},{"version":"v1.30.4+rke2r1"}]}`,
+			wantApps:     `{"appDefaults":[{"appName":"rancher","defaults":[{"appVersion":"\u003e= 2.9","defaultVersion":"1.30.x"},{"appVersion":"\u003e= 2.10","defaultVersion":"1.31.x"}]}]}`,
+		},
+		{
+			name:         "chain",
+			config:       base + "rke2:\n  extends: k3s\n  redirectBase: https://rke2.example.com/\nk3k:\n  extends: rke2\n  channels:\n  - name: testing\n    $patch: delete\n",
+			subKey:       "k3k",
+			wantChannels: `{"channels":[{"name":"stable","latest":"v1.30.4+k3s1"}],"redirectBase":"https://rke2.example.com/"}`,
+			wantReleases: `{"releases":[{"version":"v1.30.4+k3s1"}]}`,
+			wantApps:     `{"appDefaults":[{"appName":"rancher","defaults":[{"appVersion":"\u003e= 2.9","defaultVersion":"1.30.x"}]}]}`,
+		},
+		{
+			name:    "cycle",
+			config:  "k3s:\n  extends: rke2\nrke2:\n  extends: k3s\n",
+			subKey:  "k3s",
+			wantErr: "cycle",
+		},
+		{
+			name:    "missing subkey",
+			config:  base + "rke2:\n  extends: k3k\n",
+			subKey:  "rke2",
+			wantErr: "which is not in config",
+		},
+		{
+			name:    "not a string",
+			config:  base + "rke2:\n  extends: [k3s]\n",
+			subKey:  "rke2",
+			wantErr: "must be a string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channels, err := GetChannelsConfig(context.Background(), []byte(tt.config), tt.subKey)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			releases, err := GetReleasesConfig([]byte(tt.config), "", tt.subKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			apps, err := GetAppDefaultsConfig([]byte(tt.config), tt.subKey, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, view := range []struct {
+				name string
+				got  interface{}
+				want string
+			}{
+				{name: "channels", got: channels, want: tt.wantChannels},
+				{name: "releases", got: releases, want: tt.wantReleases},
+				{name: "appDefaults", got: apps, want: tt.wantApps},
+			} {
+				data, err := json.Marshal(view.got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != view.want {
+					t.Errorf("%s: got %s\nwant %s", view.name, data, view.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetConfigMissingSubKey(t *testing.T) {
+	content := []byte("k3s:\n  channels:\n  - name: stable\n    latest: v1.30.4+k3s1\n")
+
+	tests := []struct {
+		name    string
+		get     func() (interface{}, error)
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "channels",
+			get:     func() (interface{}, error) { return GetChannelsConfig(context.Background(), content, "rke2") },
+			wantErr: "failed to find key rke2 in config",
+		},
+		{
+			// As ever, a config without the subkey has no releases.
+			name: "releases",
+			get:  func() (interface{}, error) { return GetReleasesConfig(content, "v2.9.0", "rke2") },
+			want: `{}`,
+		},
+		{
+			name:    "app defaults",
+			get:     func() (interface{}, error) { return GetAppDefaultsConfig(content, "rke2", "rancher") },
+			wantErr: "failed to find key rke2 in config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.get()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := json.Marshal(got); string(data) != tt.want {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
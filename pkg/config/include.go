@@ -0,0 +1,295 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// includeKey is the key of an include directive. A mapping holding only the
+// directive is replaced by the included document, or by the documents of a
+// list of includes combined. Other keys next to the directive override keys
+// of the included mappings, and an include in a list whose document is a
+// list is spliced into it.
+const includeKey = "$include"
+
+// maxIncludeDepth is how deeply includes can be nested.
+const maxIncludeDepth = 10
+
+// ResolvingSource is implemented by sources that can locate content by a
+// path relative to their own, such as an included file.
+type ResolvingSource interface {
+	Source
+	Resolve(ref string) (Source, error)
+}
+
+// resolveIncludes replaces the include directives in the parsed config doc
+// for subKey and the subkeys it extends, or in the whole config if subKey is
+// empty, with the content they refer to, passing the content of every
+// included source to check. Relative references are resolved against the
+// source that holds them, and absolute URLs through the registered sources.
+// The content of the included sources is returned by URL, and the items of
+// included documents record the source as their provenance.
+//
+// Included sources must have the origin, the scheme and host, of the source
+// including them, or one of origins, so that a config cannot make the
+// server read local files or reach other hosts.
+func resolveIncludes(ctx context.Context, source Source, doc interface{}, subKey string, origins []string, check func(context.Context, Source, []byte) error) (interface{}, map[string][]byte, error) {
+	r := &includer{
+		ctx:      ctx,
+		check:    check,
+		origins:  origins,
+		stack:    []string{source.URL()},
+		included: map[string][]byte{},
+	}
+	if subKey == "" {
+		resolved, err := r.resolve(source, doc)
+		return resolved, r.included, err
+	}
+
+	data, ok := doc.(map[string]interface{})
+	if !ok {
+		return doc, nil, nil
+	}
+	// The subkeys extended by subKey are resolved too, as their config is
+	// inherited.
+	for key, seen := subKey, map[string]bool{}; data[key] != nil && !seen[key]; {
+		seen[key] = true
+		resolved, err := r.resolve(source, data[key])
+		if err != nil {
+			return nil, nil, err
+		}
+		data[key] = resolved
+		extended, _ := resolved.(map[string]interface{})
+		key, _ = extended[extendsKey].(string)
+	}
+	return data, r.included, nil
+}
+
+type includer struct {
+	ctx      context.Context
+	check    func(context.Context, Source, []byte) error
+	origins  []string
+	stack    []string
+	included map[string][]byte
+}
+
+func (r *includer) resolve(from Source, node interface{}) (interface{}, error) {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		return r.resolveMap(from, node)
+	case []interface{}:
+		result := make([]interface{}, 0, len(node))
+		for _, item := range node {
+			if data, ok := item.(map[string]interface{}); ok && len(data) == 1 && data[includeKey] != nil {
+				included, err := r.includeAll(from, data[includeKey])
+				if err != nil {
+					return nil, err
+				}
+				if items, ok := included.([]interface{}); ok {
+					result = append(result, items...)
+					continue
+				}
+				result = append(result, included)
+				continue
+			}
+			resolved, err := r.resolve(from, item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, resolved)
+		}
+		return result, nil
+	}
+	return node, nil
+}
+
+func (r *includer) resolveMap(from Source, node map[string]interface{}) (interface{}, error) {
+	result := map[string]interface{}{}
+	if refs, ok := node[includeKey]; ok {
+		included, err := r.includeAll(from, refs)
+		if err != nil {
+			return nil, err
+		}
+		if len(node) == 1 {
+			return included, nil
+		}
+		data, ok := included.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s next to other keys must include a mapping, found %T", includeKey, included)
+		}
+		for key, value := range data {
+			result[key] = value
+		}
+	}
+
+	for key, value := range node {
+		if key == includeKey {
+			continue
+		}
+		resolved, err := r.resolve(from, value)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = resolved
+	}
+	return result, nil
+}
+
+// includeAll includes the documents referenced by refs, a single reference
+// or a list of them. Several mappings are merged in order and several lists
+// are concatenated.
+func (r *includer) includeAll(from Source, refs interface{}) (interface{}, error) {
+	var list []interface{}
+	switch refs := refs.(type) {
+	case string:
+		return r.include(from, refs)
+	case []interface{}:
+		list = refs
+	default:
+		return nil, fmt.Errorf("%s must be a string or a list of strings, found %T", includeKey, refs)
+	}
+
+	var result interface{}
+	for _, ref := range list {
+		s, ok := ref.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string or a list of strings, found %T in list", includeKey, ref)
+		}
+		included, err := r.include(from, s)
+		if err != nil {
+			return nil, err
+		}
+		switch value := included.(type) {
+		case map[string]interface{}:
+			merged, ok := result.(map[string]interface{})
+			if result != nil && !ok {
+				return nil, fmt.Errorf("cannot combine mapping included from %s with a %T", s, result)
+			}
+			if merged == nil {
+				merged = map[string]interface{}{}
+			}
+			for key, item := range value {
+				merged[key] = item
+			}
+			result = merged
+		case []interface{}:
+			items, ok := result.([]interface{})
+			if result != nil && !ok {
+				return nil, fmt.Errorf("cannot combine list included from %s with a %T", s, result)
+			}
+			result = append(items, value...)
+		default:
+			return nil, fmt.Errorf("only mappings or lists can be included together, found %T in %s", included, s)
+		}
+	}
+	return result, nil
+}
+
+func (r *includer) include(from Source, ref string) (interface{}, error) {
+	source, err := resolveRef(from, ref)
+	if err == nil {
+		err = checkOrigin(from, source, r.origins)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to include %s from %s: %w", ref, from.URL(), err)
+	}
+	for _, url := range r.stack {
+		if url == source.URL() {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(r.stack, " -> "), url)
+		}
+	}
+	if len(r.stack) > maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested more than %d deep: %s -> %s", maxIncludeDepth, strings.Join(r.stack, " -> "), source.URL())
+	}
+
+	content, err := source.Fetch(r.ctx)
+	if err == nil && r.check != nil {
+		err = r.check(r.ctx, source, content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to include %s from %s: %w", source.URL(), from.URL(), err)
+	}
+
+	doc, err := parseSource(content, DetectFormat(source.URL(), "", content), source.URL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s included from %s: %w", source.URL(), from.URL(), err)
+	}
+
+	r.included[source.URL()] = content
+	r.stack = append(r.stack, source.URL())
+	defer func() {
+		r.stack = r.stack[:len(r.stack)-1]
+	}()
+	return r.resolve(source, doc)
+}
+
+// resolveRef returns the source ref refers to. Absolute URLs are read
+// through the registered sources, except that URLs on the same host as an
+// HTTP source share its settings.
+func resolveRef(from Source, ref string) (Source, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("empty %s", includeKey)
+	}
+	u, err := url.Parse(ref)
+	if err == nil && u.Scheme != "" && !isWindowsPath(ref) {
+		if h, ok := from.(*HTTPSource); ok && (u.Scheme == "http" || u.Scheme == "https") {
+			return h.Resolve(ref)
+		}
+		return NewSource(ref)
+	}
+
+	resolver, ok := from.(ResolvingSource)
+	if !ok {
+		return nil, fmt.Errorf("relative includes are not supported by %s", from.URL())
+	}
+	return resolver.Resolve(ref)
+}
+
+// checkOrigin returns an error unless source has the origin of from, or one
+// of origins.
+func checkOrigin(from, source Source, origins []string) error {
+	origin := urlOrigin(canonicalURL(source))
+	if origin == urlOrigin(canonicalURL(from)) {
+		return nil
+	}
+	for _, allowed := range origins {
+		if origin == urlOrigin(allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not the origin of the including file and not an allowed include origin", origin)
+}
+
+// canonicalURL returns the URL of source as the source registered for its
+// scheme reports it, so that a StringSource of a path has the file:// URL of
+// a FileSource.
+func canonicalURL(source Source) string {
+	if s, ok := source.(StringSource); ok {
+		if src, err := NewSource(string(s)); err == nil {
+			return src.URL()
+		}
+	}
+	return source.URL()
+}
+
+// urlOrigin returns the scheme and host of rawURL. URLs without a scheme
+// are paths, read from local files.
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || isWindowsPath(rawURL) {
+		return "file://"
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// joinPath resolves the slash separated path ref against the directory of
+// base. Absolute refs are relative to the root.
+func joinPath(base, ref string) string {
+	if strings.HasPrefix(ref, "/") {
+		return strings.TrimPrefix(path.Clean(ref), "/")
+	}
+	return strings.TrimPrefix(path.Join(path.Dir(base), ref), "/")
+}
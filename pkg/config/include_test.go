@@ -0,0 +1,204 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// includeFrom resolves the includes of the config for subKey in the content
+// of source, returning it as JSON without provenance.
+func includeFrom(t *testing.T, source Source, subKey string, origins []string) (string, error) {
+	t.Helper()
+	content, err := source.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := parseSource(content, DetectFormat(source.URL(), "", content), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _, err = resolveIncludes(context.Background(), source, doc, subKey, origins, nil)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(withoutProvenance(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), nil
+}
+
+func TestResolveIncludes(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "relative mapping",
+			files: map[string]string{
+				"channels.yaml":     "k3s:\n  $include: k3s/base.yaml\n  redirectBase: https://mirror.example.com/\n",
+				"k3s/base.yaml":     "channels:\n- $include: channels.yaml\nredirectBase: https://github.com/\n",
+				"k3s/channels.yaml": "- name: stable\n  latest: v1.30.4+k3s1\n",
+			},
+			want: `{"k3s":{"channels":[{"latest":"v1.30.4+k3s1","name":"stable"}],"redirectBase":"https://mirror.example.com/"}}`,
+		},
+		{
+			name: "list of includes",
+			files: map[string]string{
+				"channels.yaml":      "k3s:\n  channels:\n  - name: latest\n    latest: v1.31.0+k3s1\n  - $include: [stable.yaml, lists/testing.yaml]\n",
+				"stable.yaml":        "- name: stable\n  latest: v1.30.4+k3s1\n",
+				"lists/testing.yaml": "- name: testing\n  latest: v1.32.0+k3s1\n",
+			},
+			want: `{"k3s":{"channels":[{"latest":"v1.31.0+k3s1","name":"latest"},{"latest":"v1.30.4+k3s1","name":"stable"},{"latest":"v1.32.0+k3s1","name":"testing"}]}}`,
+		},
+		{
+			name: "other keys are not resolved",
+			files: map[string]string{
+				"channels.yaml": "k3s:\n  channels: []\nrke2:\n  $include: missing.yaml\n",
+			},
+			want: `{"k3s":{"channels":[]},"rke2":{"$include":"missing.yaml"}}`,
+		},
+		{
+			name: "extended keys are resolved",
+			files: map[string]string{
+				"channels.yaml": "base:\n  $include: base.yaml\nk3s:\n  extends: base\n",
+				"base.yaml":     "channels: []\n",
+			},
+			want: `{"base":{"channels":[]},"k3s":{"extends":"base"}}`,
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"channels.yaml": "k3s:\n  $include: a.yaml\n",
+				"a.yaml":        "channels:\n  $include: b.yaml\n",
+				"b.yaml":        "$include: a.yaml\n",
+			},
+			wantErr: "include cycle",
+		},
+		{
+			name:    "nested too deep",
+			files:   chainedIncludes(maxIncludeDepth + 1),
+			wantErr: "nested more than",
+		},
+		{
+			name:  "nested as deep as allowed",
+			files: chainedIncludes(maxIncludeDepth),
+			want:  `{"k3s":{"channels":[]}}`,
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"channels.yaml": "k3s:\n  $include: missing.yaml\n",
+			},
+			wantErr: "failed to include",
+		},
+		{
+			name: "mapping next to other keys",
+			files: map[string]string{
+				"channels.yaml": "k3s:\n  $include: list.yaml\n  channels: []\n",
+				"list.yaml":     "- name: stable\n",
+			},
+			wantErr: "must include a mapping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), content)
+			}
+			got, err := includeFrom(t, &FileSource{Path: filepath.Join(dir, "channels.yaml")}, "k3s", nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// chainedIncludes returns files in which the config includes a file that
+// includes another, depth times.
+func chainedIncludes(depth int) map[string]string {
+	files := map[string]string{"channels.yaml": "k3s:\n  $include: 1.yaml\n"}
+	for i := 1; i < depth; i++ {
+		files[fmt.Sprintf("%d.yaml", i)] = fmt.Sprintf("$include: %d.yaml\n", i+1)
+	}
+	files[fmt.Sprintf("%d.yaml", depth)] = "channels: []\n"
+	return files
+}
+
+func TestResolveIncludesOrigins(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("- name: stable\n"))
+	}))
+	defer other.Close()
+	local := writeFile(t, filepath.Join(t.TempDir(), "channels.yaml"), "- name: stable\n")
+
+	tests := []struct {
+		name    string
+		ref     string
+		origins []string
+		wantErr bool
+	}{
+		{name: "relative", ref: "k3s/channels.yaml"},
+		{name: "absolute path", ref: "/k3s/channels.yaml"},
+		{name: "same origin", ref: "{server}/k3s/channels.yaml"},
+		{name: "local file", ref: "file://" + filepath.ToSlash(local), wantErr: true},
+		{name: "other host", ref: other.URL + "/channels.yaml", wantErr: true},
+		{name: "other host without scheme", ref: "//" + strings.TrimPrefix(other.URL, "http://") + "/channels.yaml", wantErr: true},
+		{name: "allowed origin", ref: other.URL + "/channels.yaml", origins: []string{other.URL}},
+		{name: "allowed origin with path", ref: other.URL + "/channels.yaml", origins: []string{other.URL + "/config/"}},
+		{name: "other scheme of an allowed host", ref: other.URL + "/channels.yaml", origins: []string{strings.Replace(other.URL, "http:", "https:", 1)}, wantErr: true},
+		{name: "allowed local files", ref: "file://" + filepath.ToSlash(local), origins: []string{"file://"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/channels.yaml":
+					fmt.Fprintf(w, "k3s:\n  channels:\n    $include: %s\n", strings.ReplaceAll(tt.ref, "{server}", server.URL))
+				case "/k3s/channels.yaml":
+					w.Write([]byte("- name: stable\n"))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			source, err := NewSource(server.URL + "/channels.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := includeFrom(t, source, "k3s", tt.origins)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "not an allowed include origin") {
+					t.Fatalf("expected the origin to be rejected, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := `{"k3s":{"channels":[{"name":"stable"}]}}`; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AllKeys is the config key that selects every top-level key of the config.
+const AllKeys = "*"
+
+// loaded is the content key discovery last loaded, shared by the configs of
+// the discovered keys so that the sources and overlays are fetched, checked
+// and parsed once for all of them.
+type loaded struct {
+	index   int
+	meta    FetchMeta
+	content []byte
+	// doc is the parsed content with the provenance of its items recorded,
+	// before includes are resolved and overlays applied.
+	doc      interface{}
+	overlays [][]byte
+	keys     []string
+}
+
+// KeyWatcher loads the top-level keys of a config, and the configs of those
+// keys from the same content.
+type KeyWatcher struct {
+	c *Config
+
+	lock    sync.Mutex
+	loaded  *loaded
+	configs map[*Config]bool
+}
+
+// NewKeyWatcher returns a KeyWatcher loading the keys of the config in urls,
+// with the overlays of opts applied.
+func NewKeyWatcher(urls []Source, opts Options) *KeyWatcher {
+	return &KeyWatcher{
+		c:       newConfig(AllKeys, urls, opts),
+		configs: map[*Config]bool{},
+	}
+}
+
+// Watch loads the keys and calls found with them every time they are
+// loaded, after which the configs started for keys are reloaded from the
+// same content. Keys are loaded once before Watch returns, and again
+// whenever wait returns until ctx is done, when the sources are closed. Only
+// keys holding a mapping are reported, as other keys cannot hold the config
+// of a product. If the keys cannot be loaded initially, those last cached in
+// the cache directory of the options are reported, and if there are none an
+// error is returned and the keys are not loaded again.
+func (w *KeyWatcher) Watch(ctx context.Context, wait Wait, found func(keys []string)) error {
+	context.AfterFunc(ctx, w.c.close)
+
+	var keys []string
+	load := func(initial bool) error {
+		next, err := w.loadKeys(ctx)
+		if resultWait, ok := wait.(ResultWait); ok {
+			resultWait.Result(err)
+		}
+		if err != nil && initial {
+			var cacheErr error
+			if next, cacheErr = w.cachedKeys(); cacheErr != nil {
+				return fmt.Errorf("failed to load config keys from %v: %w (cache: %v)", sourceURLs(w.c.urls), err, cacheErr)
+			}
+			logrus.Warnf("Failed to load config keys from %v, serving keys cached at %s: %v", sourceURLs(w.c.urls), w.c.Status().LoadedAt.Format(time.RFC3339), err)
+		} else if err != nil {
+			logrus.Errorf("Failed to load config keys from %v: %v", sourceURLs(w.c.urls), err)
+			return nil
+		}
+		if keys == nil || !slices.Equal(keys, next) {
+			logrus.Infof("Found config keys %v in %s", next, w.c.Status().Source)
+			keys = next
+		}
+
+		// Configs started by found are loaded as they start.
+		started := w.started()
+		found(keys)
+		if err == nil {
+			w.reload(ctx, started)
+		}
+		return nil
+	}
+
+	if err := load(true); err != nil {
+		return err
+	}
+	if wait == nil {
+		return nil
+	}
+	watched := w.c.watch(ctx)
+	go func() {
+		for next(ctx, wait, watched) {
+			load(false)
+		}
+	}()
+	return nil
+}
+
+// StartConfig starts serving the config of key from the content the keys
+// were loaded from, like StartConfig does from the sources. The config is
+// reloaded whenever the keys are, until ctx is done. If the keys have not
+// been loaded from a source its cached config is served.
+func (w *KeyWatcher) StartConfig(ctx context.Context, key string) (*Config, error) {
+	c := newConfig(key, w.c.urls, Options{
+		ChannelServerVersion: w.c.channelServerVersion,
+		AppName:              w.c.appName,
+		GitHubToken:          w.c.ghToken,
+		CacheDir:             w.c.cacheDir,
+		Verifier:             w.c.verifier,
+		Pins:                 w.c.pins,
+		Overlays:             w.c.overlays,
+		Validation:           w.c.validation,
+		IncludeOrigins:       w.c.includeOrigins,
+		Variables:            w.c.variables,
+	})
+	c.keys = w
+
+	if err := c.LoadConfig(ctx); err != nil {
+		if cacheErr := c.loadCache(ctx); cacheErr != nil {
+			return nil, fmt.Errorf("failed to load initial config for %s: %w (cache: %v)", key, err, cacheErr)
+		}
+		logrus.Warnf("Failed to load initial config for %s, serving stale configuration cached at %s: %v", key, c.Status().LoadedAt.Format(time.RFC3339), err)
+	}
+
+	w.lock.Lock()
+	w.configs[c] = true
+	w.lock.Unlock()
+	context.AfterFunc(ctx, func() {
+		w.lock.Lock()
+		delete(w.configs, c)
+		w.lock.Unlock()
+	})
+	return c, nil
+}
+
+func (w *KeyWatcher) latest() *loaded {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.loaded
+}
+
+func (w *KeyWatcher) started() []*Config {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	configs := make([]*Config, 0, len(w.configs))
+	for c := range w.configs {
+		configs = append(configs, c)
+	}
+	return configs
+}
+
+// reload loads configs again from the content last loaded, unless ctx or
+// the context they were started with is done.
+func (w *KeyWatcher) reload(ctx context.Context, configs []*Config) {
+	for _, c := range configs {
+		w.lock.Lock()
+		running := w.configs[c]
+		w.lock.Unlock()
+		if !running || ctx.Err() != nil {
+			continue
+		}
+		changed, err := c.loadConfig(ctx)
+		if err != nil {
+			logrus.Errorf("Failed to reload configuration for %s: %v", c.subKey, err)
+		} else if changed {
+			status := c.Status()
+			logrus.Infof("Reloaded configuration for %s from %s (%s)", c.subKey, status.Source, status.Digest)
+		}
+	}
+}
+
+// loadKeys loads the content of the config and returns its sorted top-level
+// keys.
+func (w *KeyWatcher) loadKeys(ctx context.Context) ([]string, error) {
+	c := w.c
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	content, meta, index, err := getURLs(ctx, c.Status().Source, c.meta, c.recordFetch, c.checkContent, c.urls...)
+	if errors.Is(err, ErrNotModified) {
+		if previous := w.latest(); previous != nil && len(c.overlays) == 0 {
+			return previous.keys, nil
+		}
+		content = c.content
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get content from any source: %w", err)
+	}
+	c.meta = meta
+	c.content = content
+
+	doc, err := parseSource(content, DetectFormat(c.urls[index].URL(), meta.ContentType, content), "")
+	if err != nil {
+		return nil, err
+	}
+	overlays, err := fetchOverlays(ctx, c.overlays, c.checkContent)
+	if err != nil {
+		return nil, err
+	}
+	// Overlays may add or remove keys. They are applied to a copy, as the
+	// configs of the keys apply them to doc themselves.
+	overlaid, err := applyOverlays(deepCopy(doc), c.subKey, c.overlays, overlays, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := topLevelKeys(overlaid)
+	w.lock.Lock()
+	w.loaded = &loaded{index: index, meta: meta, content: content, doc: doc, overlays: overlays, keys: keys}
+	w.lock.Unlock()
+
+	c.Lock()
+	c.stale = false
+	c.loadedAt = time.Now()
+	c.Unlock()
+	c.setSource(c.urls[index].URL(), meta.ETag, "")
+	if c.cacheDir != "" {
+		if data, err := json.Marshal(withoutProvenance(overlaid)); err == nil {
+			c.writeCache(data)
+		}
+	}
+	return keys, nil
+}
+
+// cachedKeys returns the keys of the config last cached by loadKeys.
+func (w *KeyWatcher) cachedKeys() ([]string, error) {
+	entry, err := w.c.readCache()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseConfig(entry.Content, FormatJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cached config: %w", err)
+	}
+	w.c.Lock()
+	w.c.stale = true
+	w.c.loadedAt = entry.LoadedAt
+	w.c.Unlock()
+	w.c.setSource(entry.Source, entry.Revision, "")
+	return topLevelKeys(doc), nil
+}
+
+// topLevelKeys returns the sorted keys of doc that hold a mapping and can be
+// used in a path prefix.
+func topLevelKeys(doc interface{}) []string {
+	data, _ := doc.(map[string]interface{})
+	keys := []string{}
+	for key, value := range data {
+		if _, ok := value.(map[string]interface{}); ok && key != "" && !strings.Contains(key, "/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
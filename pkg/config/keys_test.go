@@ -0,0 +1,152 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stepWait is a Wait that returns each time a value is sent to it.
+type stepWait chan struct{}
+
+func (w stepWait) Wait(ctx context.Context) bool {
+	select {
+	case <-w:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+const testKeysConfig = `k3s:
+  channels:
+  - name: stable
+    latest: v1.30.4+k3s1
+rke2:
+  channels:
+  - name: stable
+    latest: v1.30.4+rke2r1
+description: channels of every product
+`
+
+// watchKeys watches the keys of w until the test ends, starting a config
+// for each key found. The keys found are sent to the returned channel.
+func watchKeys(t *testing.T, w *KeyWatcher, wait Wait) (<-chan []string, func(key string) *Config) {
+	t.Helper()
+	ctx := t.Context()
+	var (
+		lock    sync.Mutex
+		configs = map[string]*Config{}
+	)
+	found := make(chan []string, 10)
+	err := w.Watch(ctx, wait, func(keys []string) {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, key := range keys {
+			if configs[key] != nil {
+				continue
+			}
+			c, err := w.StartConfig(ctx, key)
+			if err != nil {
+				t.Errorf("failed to start config for %s: %v", key, err)
+				continue
+			}
+			configs[key] = c
+		}
+		found <- keys
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found, func(key string) *Config {
+		lock.Lock()
+		defer lock.Unlock()
+		return configs[key]
+	}
+}
+
+func TestKeyWatcher(t *testing.T) {
+	source := newTestSource("test://channels.yaml", testKeysConfig)
+	wait := make(stepWait)
+	found, config := watchKeys(t, NewKeyWatcher([]Source{source}, Options{}), wait)
+
+	if keys := <-found; !slices.Equal(keys, []string{"k3s", "rke2"}) {
+		t.Fatalf("found keys %v, want [k3s rke2]", keys)
+	}
+	if got := channelNames(config("rke2")); !slices.Equal(got, []string{"stable=v1.30.4+rke2r1"}) {
+		t.Errorf("served rke2 channels %v", got)
+	}
+	if source.fetched() != 1 {
+		t.Errorf("fetched %d times for two keys, want once", source.fetched())
+	}
+
+	source.set(`k3s:
+  channels:
+  - name: stable
+    latest: v1.31.0+k3s1
+k3k:
+  channels: []
+`, nil)
+	wait <- struct{}{}
+	if keys := <-found; !slices.Equal(keys, []string{"k3k", "k3s"}) {
+		t.Fatalf("found keys %v, want [k3k k3s]", keys)
+	}
+	// Configs started before are reloaded once the keys were found.
+	deadline := time.After(5 * time.Second)
+	for !slices.Equal(channelNames(config("k3s")), []string{"stable=v1.31.0+k3s1"}) {
+		select {
+		case <-deadline:
+			t.Fatalf("served k3s channels %v after reload", channelNames(config("k3s")))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if source.fetched() != 2 {
+		t.Errorf("fetched %d times for two loads of three keys, want twice", source.fetched())
+	}
+	if health := config("k3s").SourceHealth(); len(health) != 1 || !health[0].Healthy {
+		t.Errorf("source health %+v of a key, want that of the key discovery", health)
+	}
+}
+
+func TestKeyWatcherCache(t *testing.T) {
+	dir := t.TempDir()
+	source := newTestSource("test://channels.yaml", testKeysConfig)
+
+	found, _ := watchKeys(t, NewKeyWatcher([]Source{source}, Options{CacheDir: dir}), nil)
+	<-found
+
+	source.set("", errors.New("unavailable"))
+	found, config := watchKeys(t, NewKeyWatcher([]Source{source}, Options{CacheDir: dir}), nil)
+	select {
+	case keys := <-found:
+		if !slices.Equal(keys, []string{"k3s", "rke2"}) {
+			t.Fatalf("found cached keys %v, want [k3s rke2]", keys)
+		}
+	default:
+		t.Fatal("no keys found while the source is unavailable")
+	}
+	c := config("k3s")
+	if c == nil {
+		t.Fatal("cached config of k3s not served")
+	}
+	if !c.Status().Stale {
+		t.Error("cached config not reported as stale")
+	}
+	if got := channelNames(c); !slices.Equal(got, []string{"stable=v1.30.4+k3s1"}) {
+		t.Errorf("served cached k3s channels %v", got)
+	}
+}
+
+func TestKeyWatcherError(t *testing.T) {
+	source := newTestSource("test://channels.yaml", "")
+	source.set("", errors.New("unavailable"))
+	err := NewKeyWatcher([]Source{source}, Options{CacheDir: t.TempDir()}).Watch(t.Context(), nil, func([]string) {
+		t.Error("keys found while the source is unavailable and not cached")
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,408 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// mergeKeys are the fields identifying the items of the lists of the config
+// format, by the name of the list. Items of these lists are merged by key by
+// a strategic merge patch, while other lists are replaced.
+var mergeKeys = map[string]string{
+	"channels":    "name",
+	"releases":    "version",
+	"appDefaults": "appName",
+	"defaults":    "appVersion",
+}
+
+// patchKey is the directive of a strategic merge patch that removes the
+// mapping or list item holding it with the value "delete", or replaces it
+// instead of merging with the value "replace".
+const patchKey = "$patch"
+
+// applyPatch applies a patch, a document of an overlay, to doc. A patch
+// holding a list is a JSON patch (RFC 6902), and one holding a mapping is a
+// strategic merge patch, which merges mappings recursively and merges the
+// items of channels, releases, appDefaults and defaults by name, version,
+// appName and appVersion.
+func applyPatch(doc, patch interface{}) (interface{}, error) {
+	switch patch := patch.(type) {
+	case []interface{}:
+		return jsonPatch(doc, patch)
+	case map[string]interface{}:
+		return mergePatch(doc, patch, ""), nil
+	case nil:
+		return doc, nil
+	}
+	return nil, fmt.Errorf("overlay must be a JSON patch list or a strategic merge mapping, found %T", patch)
+}
+
+// fetchOverlays fetches the content of the overlays in order, passing the
+// content of each to check.
+func fetchOverlays(ctx context.Context, overlays []Source, check func(context.Context, Source, []byte) error) ([][]byte, error) {
+	contents := make([][]byte, 0, len(overlays))
+	for _, overlay := range overlays {
+		content, err := overlay.Fetch(ctx)
+		if err == nil && check != nil {
+			err = check(ctx, overlay, content)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get overlay %s: %w", overlay.URL(), err)
+		}
+		contents = append(contents, content)
+	}
+	return contents, nil
+}
+
+// applyOverlays applies contents, the fetched content of the overlays, to
+// the parsed config doc in order. The items of the config for subKey and
+// those it extends record the overlays that changed them, and the content
+// of each overlay is added to files.
+func applyOverlays(doc interface{}, subKey string, overlays []Source, contents [][]byte, files map[string][]byte) (interface{}, error) {
+	for i, overlay := range overlays {
+		content := contents[i]
+		patches, err := parseDocuments(content, DetectFormat(overlay.URL(), "", content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply overlay %s: %w", overlay.URL(), err)
+		}
+		before := itemStates(configsOf(doc, subKey))
+		for i, patch := range patches {
+			if _, ok := patch.(map[string]interface{}); ok {
+				annotate(patch, overlay.URL(), i)
+			}
+			if doc, err = applyPatch(doc, patch); err != nil {
+				return nil, fmt.Errorf("failed to apply overlay %s: %w", overlay.URL(), err)
+			}
+		}
+		markOverlaid(configsOf(doc, subKey), before, overlay.URL())
+		if files != nil {
+			files[overlay.URL()] = content
+		}
+	}
+	return doc, nil
+}
+
+// mergePatch applies a strategic merge patch to doc. name is the key doc is
+// stored at in its parent mapping.
+func mergePatch(doc, patch interface{}, name string) interface{} {
+	switch patch := patch.(type) {
+	case map[string]interface{}:
+		data, ok := doc.(map[string]interface{})
+		if !ok || patch[patchKey] == "replace" {
+			return withoutDirective(patch)
+		}
+		result := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			result[key] = value
+		}
+		for key, value := range patch {
+			if key == patchKey {
+				continue
+			}
+			if value == nil {
+				delete(result, key)
+				continue
+			}
+			if key == provenanceKey {
+				// The provenance of the patch replaces that of doc, as it
+				// is where the merged item was last defined.
+				result[key] = value
+				continue
+			}
+			if directive, ok := value.(map[string]interface{}); ok && directive[patchKey] == "delete" {
+				delete(result, key)
+				continue
+			}
+			result[key] = mergePatch(result[key], value, key)
+		}
+		return result
+	case []interface{}:
+		items, ok := doc.([]interface{})
+		if mergeKey := mergeKeys[name]; ok && mergeKey != "" {
+			return mergeList(items, patch, mergeKey)
+		}
+		return patch
+	}
+	return patch
+}
+
+// mergeList merges the items of patch into items by the value of mergeKey.
+// Items not in items yet are appended.
+func mergeList(items, patch []interface{}, mergeKey string) []interface{} {
+	result := append([]interface{}{}, items...)
+	for _, item := range patch {
+		data, ok := item.(map[string]interface{})
+		if !ok || data[mergeKey] == nil {
+			result = append(result, item)
+			continue
+		}
+		index := -1
+		for i, existing := range result {
+			if existing, ok := existing.(map[string]interface{}); ok && reflect.DeepEqual(existing[mergeKey], data[mergeKey]) {
+				index = i
+				break
+			}
+		}
+		switch {
+		case data[patchKey] == "delete":
+			if index >= 0 {
+				result = append(result[:index], result[index+1:]...)
+			}
+		case index >= 0:
+			result[index] = mergePatch(result[index], data, "")
+		default:
+			result = append(result, withoutDirective(data))
+		}
+	}
+	return result
+}
+
+func withoutDirective(data map[string]interface{}) map[string]interface{} {
+	return withoutKey(data, patchKey)
+}
+
+func withoutKey(data map[string]interface{}, without string) map[string]interface{} {
+	result := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if key != without {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// jsonPatch applies the operations of a JSON patch to doc.
+func jsonPatch(doc interface{}, ops []interface{}) (interface{}, error) {
+	for i, op := range ops {
+		var operation struct {
+			Op    string      `json:"op"`
+			Path  *string     `json:"path"`
+			From  *string     `json:"from"`
+			Value interface{} `json:"value"`
+		}
+		data, err := json.Marshal(op)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &operation); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		if operation.Path == nil {
+			return nil, fmt.Errorf("operation %d: missing path", i)
+		}
+		path := *operation.Path
+		_, hasValue := op.(map[string]interface{})["value"]
+
+		switch operation.Op {
+		case "add", "replace", "test":
+			if !hasValue {
+				return nil, fmt.Errorf("operation %d: %s at %s requires a value", i, operation.Op, path)
+			}
+		case "move", "copy":
+			if operation.From == nil {
+				return nil, fmt.Errorf("operation %d: %s to %s requires from", i, operation.Op, path)
+			}
+		}
+
+		switch operation.Op {
+		case "add":
+			doc, err = pointerAdd(doc, path, operation.Value)
+		case "remove":
+			doc, _, err = pointerRemove(doc, path)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, path); err == nil {
+				doc, err = pointerAdd(doc, path, operation.Value)
+			}
+		case "move":
+			var value interface{}
+			if strings.HasPrefix(path, *operation.From+"/") {
+				err = fmt.Errorf("cannot move %s into its own child %s", *operation.From, path)
+			} else if doc, value, err = pointerRemove(doc, *operation.From); err == nil {
+				doc, err = pointerAdd(doc, path, value)
+			}
+		case "copy":
+			var value interface{}
+			if value, err = pointerGet(doc, *operation.From); err == nil {
+				doc, err = pointerAdd(doc, path, deepCopy(value))
+			}
+		case "test":
+			var value interface{}
+			if value, err = pointerGet(doc, path); err == nil && !reflect.DeepEqual(value, operation.Value) {
+				err = fmt.Errorf("test failed, value at %s is %v", path, value)
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", operation.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid path %q, must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %s not found", pointer)
+			}
+			doc = value
+		case []interface{}:
+			index, err := listIndex(node, token, false)
+			if err != nil {
+				return nil, fmt.Errorf("path %s: %w", pointer, err)
+			}
+			doc = node[index]
+		default:
+			return nil, fmt.Errorf("path %s not found", pointer)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd adds value at pointer, returning the new document.
+func pointerAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, pointer[:strings.LastIndex(pointer, "/")])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		index := len(node)
+		if last != "-" {
+			if index, err = listIndex(node, last, true); err != nil {
+				return nil, fmt.Errorf("path %s: %w", pointer, err)
+			}
+		}
+		node = append(node[:index], append([]interface{}{value}, node[index:]...)...)
+		return setParent(doc, tokens[:len(tokens)-1], node), nil
+	}
+	return nil, fmt.Errorf("path %s not found", pointer)
+}
+
+// pointerRemove removes the value at pointer, returning the new document
+// and the removed value.
+func pointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := pointerGet(doc, pointer[:strings.LastIndex(pointer, "/")])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path %s not found", pointer)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []interface{}:
+		index, err := listIndex(node, last, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("path %s: %w", pointer, err)
+		}
+		value := node[index]
+		node = append(node[:index:index], node[index+1:]...)
+		return setParent(doc, tokens[:len(tokens)-1], node), value, nil
+	}
+	return nil, nil, fmt.Errorf("path %s not found", pointer)
+}
+
+// setParent stores the list at the location of tokens, as lists change
+// identity when they grow or shrink.
+func setParent(doc interface{}, tokens []string, list []interface{}) interface{} {
+	if len(tokens) == 0 {
+		return list
+	}
+	node := doc
+	for _, token := range tokens[:len(tokens)-1] {
+		switch current := node.(type) {
+		case map[string]interface{}:
+			node = current[token]
+		case []interface{}:
+			index, _ := strconv.Atoi(token)
+			node = current[index]
+		}
+	}
+	last := tokens[len(tokens)-1]
+	switch parent := node.(type) {
+	case map[string]interface{}:
+		parent[last] = list
+	case []interface{}:
+		index, _ := strconv.Atoi(last)
+		parent[index] = list
+	}
+	return doc
+}
+
+func listIndex(list []interface{}, token string, insert bool) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid list index %q", token)
+	}
+	if index > len(list) || (!insert && index == len(list)) {
+		return 0, fmt.Errorf("list index %d out of range", index)
+	}
+	return index, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[key] = deepCopy(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = deepCopy(item)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// overlaid applies the overlay patch to the config doc and returns the
+// result as JSON, without the recorded provenance.
+func overlaid(doc, patch string) (string, error) {
+	parsed, err := parseConfig([]byte(doc), DetectFormat("", "", []byte(doc)))
+	if err != nil {
+		return "", err
+	}
+	overlay := newTestSource("test://overlay", patch)
+	result, err := applyOverlays(parsed, "", []Source{overlay}, [][]byte{[]byte(patch)}, nil)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(withoutProvenance(result))
+	return string(data), err
+}
+
+func TestApplyOverlayJSONPatch(t *testing.T) {
+	const doc = `{"k3s":{"channels":[{"name":"stable"},{"name":"testing"}],"a/b":1,"m~n":2}}`
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "add to the end of a list",
+			patch: `[{"op":"add","path":"/k3s/channels/-","value":{"name":"latest"}}]`,
+			want:  `{"k3s":{"a/b":1,"channels":[{"name":"stable"},{"name":"testing"},{"name":"latest"}],"m~n":2}}`,
+		},
+		{
+			name:  "insert into a list",
+			patch: `[{"op":"add","path":"/k3s/channels/1","value":{"name":"latest"}}]`,
+			want:  `{"k3s":{"a/b":1,"channels":[{"name":"stable"},{"name":"latest"},{"name":"testing"}],"m~n":2}}`,
+		},
+		{
+			name:    "read past the end of a list",
+			patch:   `[{"op":"replace","path":"/k3s/channels/-","value":{"name":"latest"}}]`,
+			wantErr: "invalid list index",
+		},
+		{
+			name:    "leading zero index",
+			patch:   `[{"op":"remove","path":"/k3s/channels/01"}]`,
+			wantErr: "invalid list index",
+		},
+		{
+			name:  "escaped slash",
+			patch: `[{"op":"replace","path":"/k3s/a~1b","value":3}]`,
+			want:  `{"k3s":{"a/b":3,"channels":[{"name":"stable"},{"name":"testing"}],"m~n":2}}`,
+		},
+		{
+			name:  "escaped tilde",
+			patch: `[{"op":"remove","path":"/k3s/m~0n"}]`,
+			want:  `{"k3s":{"a/b":1,"channels":[{"name":"stable"},{"name":"testing"}]}}`,
+		},
+		{
+			// ~01 is an escaped tilde followed by 1, not a slash.
+			name:    "escapes unescaped in order",
+			patch:   `[{"op":"remove","path":"/k3s/m~01n"}]`,
+			wantErr: "not found",
+		},
+		{
+			name:  "test passes",
+			patch: `[{"op":"test","path":"/k3s/channels/0","value":{"name":"stable"}},{"op":"remove","path":"/k3s/channels/0"}]`,
+			want:  `{"k3s":{"a/b":1,"channels":[{"name":"testing"}],"m~n":2}}`,
+		},
+		{
+			name:    "test fails",
+			patch:   `[{"op":"test","path":"/k3s/channels/0/name","value":"testing"},{"op":"remove","path":"/k3s/channels/0"}]`,
+			wantErr: "test failed",
+		},
+		{
+			name:    "test of a missing path",
+			patch:   `[{"op":"test","path":"/rke2","value":null}]`,
+			wantErr: "not found",
+		},
+		{
+			name:  "move",
+			patch: `[{"op":"move","from":"/k3s/channels/1","path":"/k3s/channels/0"}]`,
+			want:  `{"k3s":{"a/b":1,"channels":[{"name":"testing"},{"name":"stable"}],"m~n":2}}`,
+		},
+		{
+			name:    "move into its own child",
+			patch:   `[{"op":"move","from":"/k3s","path":"/k3s/channels/0/k3s"}]`,
+			wantErr: "into its own child",
+		},
+		{
+			name:  "move to itself",
+			patch: `[{"op":"move","from":"/k3s/a~1b","path":"/k3s/a~1b"}]`,
+			want:  `{"k3s":{"a/b":1,"channels":[{"name":"stable"},{"name":"testing"}],"m~n":2}}`,
+		},
+		{
+			name:  "copy is not shared",
+			patch: `[{"op":"copy","from":"/k3s/channels/0","path":"/k3s/channels/-"},{"op":"replace","path":"/k3s/channels/2/name","value":"latest"}]`,
+			want:  `{"k3s":{"a/b":1,"channels":[{"name":"stable"},{"name":"testing"},{"name":"latest"}],"m~n":2}}`,
+		},
+		{
+			name:    "missing value",
+			patch:   `[{"op":"add","path":"/k3s/x"}]`,
+			wantErr: "requires a value",
+		},
+		{
+			name:    "unknown op",
+			patch:   `[{"op":"merge","path":"/k3s"}]`,
+			wantErr: "unknown op",
+		},
+		{
+			name:    "error names the failed operation",
+			patch:   `[{"op":"remove","path":"/k3s/a~1b"},{"op":"remove","path":"/rke2"}]`,
+			wantErr: "operation 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := overlaid(doc, tt.patch)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOverlayMergePatch(t *testing.T) {
+	const doc = "k3s:\n  redirectBase: https://github.com/\n  channels:\n  - name: stable\n    latest: v1.30.4+k3s1\n  - name: testing\n    latest: v1.31.0+k3s1\n  releases:\n  - version: v1.30.4+k3s1\n    minChannelServerVersion: v2.9.0\n"
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "merge by name",
+			patch: "k3s:\n  channels:\n  - name: stable\n    latest: v1.30.5+k3s1\n  - name: latest\n    latest: v1.32.0+k3s1\n",
+			want:  `{"k3s":{"channels":[{"latest":"v1.30.5+k3s1","name":"stable"},{"latest":"v1.31.0+k3s1","name":"testing"},{"latest":"v1.32.0+k3s1","name":"latest"}],"redirectBase":"https://github.com/","releases":[{"minChannelServerVersion":"v2.9.0","version":"v1.30.4+k3s1"}]}}`,
+		},
+		{
+			name:  "delete an item",
+			patch: "k3s:\n  channels:\n  - name: testing\n    $patch: delete\n",
+			want:  `{"k3s":{"channels":[{"latest":"v1.30.4+k3s1","name":"stable"}],"redirectBase":"https://github.com/","releases":[{"minChannelServerVersion":"v2.9.0","version":"v1.30.4+k3s1"}]}}`,
+		},
+		{
+			name:  "merge by version",
+			patch: "k3s:\n  releases:\n  - version: v1.30.4+k3s1\n    maxChannelServerVersion: v2.10.99\n",
+			want:  `{"k3s":{"channels":[{"latest":"v1.30.4+k3s1","name":"stable"},{"latest":"v1.31.0+k3s1","name":"testing"}],"redirectBase":"https://github.com/","releases":[{"maxChannelServerVersion":"v2.10.99","minChannelServerVersion":"v2.9.0","version":"v1.30.4+k3s1"}]}}`,
+		},
+		{
+			name:  "replace a mapping",
+			patch: "k3s:\n  $patch: replace\n  channels: []\n",
+			want:  `{"k3s":{"channels":[]}}`,
+		},
+		{
+			name:  "remove keys",
+			patch: "k3s:\n  redirectBase: null\n  releases:\n    $patch: delete\n",
+			want:  `{"k3s":{"channels":[{"latest":"v1.30.4+k3s1","name":"stable"},{"latest":"v1.31.0+k3s1","name":"testing"}]}}`,
+		},
+		{
+			name:  "documents in order",
+			patch: "k3s:\n  channels:\n  - name: testing\n    $patch: delete\n---\n- op: add\n  path: /k3s/channels/-\n  value:\n    name: testing\n",
+			want:  `{"k3s":{"channels":[{"latest":"v1.30.4+k3s1","name":"stable"},{"name":"testing"}],"redirectBase":"https://github.com/","releases":[{"minChannelServerVersion":"v2.9.0","version":"v1.30.4+k3s1"}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := overlaid(doc, tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rancher/channelserver/pkg/model"
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+// provenanceKey is the key the provenance of a channel, release or app
+// default is recorded at while its config is assembled from files,
+// overlays and the subkeys it extends. It is not part of the config format.
+const provenanceKey = "$provenance"
+
+// itemLists are the lists of a config whose items record their provenance.
+var itemLists = []string{"channels", "releases", "appDefaults"}
+
+// note is the provenance recorded for an item at provenanceKey. An empty
+// File is the source the config was loaded from.
+type note struct {
+	File          string   `json:"file"`
+	Document      int      `json:"document,omitempty"`
+	Path          string   `json:"path"`
+	InheritedFrom string   `json:"inheritedFrom,omitempty"`
+	OverlaidBy    []string `json:"overlaidBy,omitempty"`
+}
+
+// annotateFile records file as the provenance of the items of doc, the
+// parsed content of file, that do not have one yet. doc is a config, a
+// mapping of configs by key, a list of items or a single item. Mappings
+// holding an include directive are left to the included file.
+func annotateFile(doc interface{}, file string) {
+	annotate(doc, file, 0)
+}
+
+// annotate is annotateFile for doc, the document at index document of a
+// file with several YAML documents.
+func annotate(doc interface{}, file string, document int) {
+	switch doc := doc.(type) {
+	case []interface{}:
+		for i, item := range doc {
+			annotateItem(item, file, document, fmt.Sprintf("[%d]", i))
+		}
+	case map[string]interface{}:
+		if isConfig(doc) {
+			annotateConfig(doc, file, document, "")
+			return
+		}
+		if isItem(doc) {
+			annotateItem(doc, file, document, "")
+			return
+		}
+		for key, value := range doc {
+			if config, ok := value.(map[string]interface{}); ok && isConfig(config) {
+				annotateConfig(config, file, document, key)
+			}
+		}
+	}
+}
+
+func annotateConfig(config map[string]interface{}, file string, document int, key string) {
+	for _, list := range itemLists {
+		items, _ := config[list].([]interface{})
+		for i, item := range items {
+			annotateItem(item, file, document, fmt.Sprintf("%s[%d]", joinField(key, list), i))
+		}
+	}
+}
+
+func annotateItem(item interface{}, file string, document int, path string) {
+	data, ok := item.(map[string]interface{})
+	if !ok || data[includeKey] != nil || data[provenanceKey] != nil {
+		return
+	}
+	recorded := map[string]interface{}{
+		"file": file,
+		"path": path,
+	}
+	if document > 0 {
+		recorded["document"] = document
+	}
+	data[provenanceKey] = recorded
+}
+
+func isConfig(data map[string]interface{}) bool {
+	for _, list := range itemLists {
+		if _, ok := data[list].([]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isItem(data map[string]interface{}) bool {
+	for _, list := range itemLists {
+		if data[mergeKeys[list]] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// inherited returns a copy of config with the items that do not record
+// being inherited yet recorded as inherited from key.
+func inherited(config map[string]interface{}, key string) map[string]interface{} {
+	result := shallowCopy(config)
+	for _, list := range itemLists {
+		items, ok := config[list].([]interface{})
+		if !ok {
+			continue
+		}
+		marked := make([]interface{}, len(items))
+		for i, item := range items {
+			marked[i] = item
+			data, _ := item.(map[string]interface{})
+			recorded, _ := data[provenanceKey].(map[string]interface{})
+			if recorded == nil || recorded["inheritedFrom"] != nil {
+				continue
+			}
+			recorded = shallowCopy(recorded)
+			recorded["inheritedFrom"] = key
+			data = shallowCopy(data)
+			data[provenanceKey] = recorded
+			marked[i] = data
+		}
+		result[list] = marked
+	}
+	return result
+}
+
+// itemState is an item of a config before an overlay is applied.
+type itemState struct {
+	digest [sha256.Size]byte
+	note   interface{}
+}
+
+// configsOf returns the config for subKey in doc and the configs it
+// extends, or doc itself if subKey is empty.
+func configsOf(doc interface{}, subKey string) []map[string]interface{} {
+	data, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if subKey == "" {
+		return []map[string]interface{}{data}
+	}
+	var configs []map[string]interface{}
+	for _, key := range extendedKeys(data, subKey) {
+		if config, ok := data[key].(map[string]interface{}); ok {
+			configs = append(configs, config)
+		}
+	}
+	return configs
+}
+
+// itemStates returns the state of the items of configs by their identity,
+// to find those an overlay changes.
+func itemStates(configs []map[string]interface{}) map[string]itemState {
+	states := map[string]itemState{}
+	forEachItem(configs, func(id string, item map[string]interface{}) {
+		states[id] = itemState{digest: itemDigest(item), note: item[provenanceKey]}
+	})
+	return states
+}
+
+// markOverlaid records overlay in the provenance of the items of configs
+// that it changed, compared to before. Items added by the overlay keep the
+// provenance recorded in it, or record it as their file.
+func markOverlaid(configs []map[string]interface{}, before map[string]itemState, overlay string) {
+	forEachItem(configs, func(id string, item map[string]interface{}) {
+		state, ok := before[id]
+		if !ok {
+			if item[provenanceKey] == nil {
+				item[provenanceKey] = map[string]interface{}{"file": overlay, "path": ""}
+			}
+			return
+		}
+		recorded, _ := state.note.(map[string]interface{})
+		if itemDigest(item) != state.digest {
+			recorded = shallowCopy(recorded)
+			overlaidBy, _ := recorded["overlaidBy"].([]interface{})
+			recorded["overlaidBy"] = append(append([]interface{}{}, overlaidBy...), overlay)
+		}
+		if recorded == nil {
+			delete(item, provenanceKey)
+			return
+		}
+		item[provenanceKey] = recorded
+	})
+}
+
+func forEachItem(configs []map[string]interface{}, f func(id string, item map[string]interface{})) {
+	for i, config := range configs {
+		for _, list := range itemLists {
+			items, _ := config[list].([]interface{})
+			for _, item := range items {
+				data, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				id, _ := json.Marshal(data[mergeKeys[list]])
+				f(fmt.Sprintf("%d/%s/%s", i, list, id), data)
+			}
+		}
+	}
+}
+
+func itemDigest(item map[string]interface{}) [sha256.Size]byte {
+	data, _ := json.Marshal(withoutKey(item, provenanceKey))
+	return sha256.Sum256(data)
+}
+
+func shallowCopy(data map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+	return result
+}
+
+// provenanceTable is the provenance recorded for the items of an assembled
+// config, by the JSON pointer of each item, kept apart from the config so
+// that its digest and cache are of the config alone.
+type provenanceTable map[string]interface{}
+
+// pointerToken escapes a key as a token of a JSON pointer.
+var pointerToken = strings.NewReplacer("~", "~0", "/", "~1")
+
+// takeProvenance removes the provenance recorded in doc, changing it in
+// place, and returns it.
+func takeProvenance(doc interface{}) provenanceTable {
+	table := provenanceTable{}
+	table.take(doc, "")
+	return table
+}
+
+func (t provenanceTable) take(node interface{}, pointer string) {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		if recorded, ok := node[provenanceKey]; ok {
+			t[pointer] = recorded
+			delete(node, provenanceKey)
+		}
+		for key, value := range node {
+			t.take(value, pointer+"/"+pointerToken.Replace(key))
+		}
+	case []interface{}:
+		for i, item := range node {
+			t.take(item, pointer+"/"+strconv.Itoa(i))
+		}
+	}
+}
+
+// restore records the provenance of the table in doc again, changing it in
+// place. doc must be the config the provenance was taken from, as it is
+// looked up by the position of the items.
+func (t provenanceTable) restore(doc interface{}) {
+	for pointer, recorded := range t {
+		if item, err := pointerGet(doc, pointer); err == nil {
+			if data, ok := item.(map[string]interface{}); ok {
+				data[provenanceKey] = recorded
+			}
+		}
+	}
+}
+
+// withoutProvenance removes the recorded provenance from doc.
+func withoutProvenance(doc interface{}) interface{} {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(doc))
+		for key, value := range doc {
+			if key != provenanceKey {
+				result[key] = withoutProvenance(value)
+			}
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(doc))
+		for i, item := range doc {
+			result[i] = withoutProvenance(item)
+		}
+		return result
+	}
+	return doc
+}
+
+// recordedNote returns the note recorded for an item at provenanceKey, or
+// nil if there is none.
+func recordedNote(recorded interface{}) *note {
+	data, ok := recorded.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	n := &note{}
+	n.File, _ = data["file"].(string)
+	n.Path, _ = data["path"].(string)
+	n.InheritedFrom, _ = data["inheritedFrom"].(string)
+	// The document is a number once the note was read from JSON.
+	switch document := data["document"].(type) {
+	case int:
+		n.Document = document
+	case float64:
+		n.Document = int(document)
+	}
+	overlaidBy, _ := data["overlaidBy"].([]interface{})
+	for _, overlay := range overlaidBy {
+		if overlay, ok := overlay.(string); ok {
+			n.OverlaidBy = append(n.OverlaidBy, overlay)
+		}
+	}
+	return n
+}
+
+// provenance returns the provenance of the item at path in the config for
+// subKey, from the note recorded for it if any.
+func (n *note) provenance(subKey, path string) *model.Provenance {
+	if n == nil {
+		return &model.Provenance{Path: path, SubKey: subKey}
+	}
+	return &model.Provenance{
+		File:          n.File,
+		Document:      n.Document,
+		Path:          n.Path,
+		SubKey:        subKey,
+		InheritedFrom: n.InheritedFrom,
+		OverlaidBy:    n.OverlaidBy,
+	}
+}
+
+// sourceFiles are the source a config was loaded from and the contents of
+// the files it was assembled from, by URL, used to find the line each item
+// was defined at. Files are only parsed for their lines when one is first
+// looked up.
+type sourceFiles struct {
+	source   string
+	contents map[string][]byte
+
+	lock  sync.Mutex
+	nodes map[string][]*yamlv3.Node
+}
+
+func newSourceFiles(source string, contents map[string][]byte) *sourceFiles {
+	return &sourceFiles{
+		source:   source,
+		contents: contents,
+		nodes:    map[string][]*yamlv3.Node{},
+	}
+}
+
+// itemPath matches the paths recorded for items: a list item of a config,
+// optionally at a key, an item of a list or a single item.
+var itemPath = regexp.MustCompile(`^(?:(?:(.*)\.)?(channels|releases|appDefaults))?(?:\[(\d+)\])?$`)
+
+// locate sets the source of p, and its file if it was defined in the
+// source itself.
+func (f *sourceFiles) locate(p *model.Provenance) {
+	if f == nil {
+		return
+	}
+	p.Source = f.source
+	if p.File == "" {
+		p.File = f.source
+	}
+}
+
+// withLine returns a copy of p with the line of the item looked up in its
+// file, if the file is known.
+func (f *sourceFiles) withLine(p *model.Provenance) *model.Provenance {
+	if p == nil {
+		return nil
+	}
+	result := *p
+	if f != nil && result.Line == 0 {
+		result.Line = f.line(result.File, result.Document, result.Path)
+	}
+	return &result
+}
+
+func (f *sourceFiles) line(file string, document int, path string) int {
+	if _, ok := f.contents[file]; !ok {
+		return 0
+	}
+	match := itemPath.FindStringSubmatch(path)
+	if match == nil {
+		return 0
+	}
+	node := f.node(file, document)
+	if node == nil || path == "" && node.Kind != yamlv3.MappingNode {
+		return 0
+	}
+	if match[1] != "" {
+		node = mappingValue(node, match[1])
+	}
+	if match[2] != "" {
+		node = mappingValue(node, match[2])
+	}
+	if match[3] != "" {
+		index, _ := strconv.Atoi(match[3])
+		if node == nil || node.Kind != yamlv3.SequenceNode || index >= len(node.Content) {
+			return 0
+		}
+		node = node.Content[index]
+	}
+	if node == nil {
+		return 0
+	}
+	return node.Line
+}
+
+// node returns the parsed content of the document at index document of
+// file, parsing the file on first use. The lines of TOML files are not
+// known, as it is not YAML.
+func (f *sourceFiles) node(file string, document int) *yamlv3.Node {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	nodes, ok := f.nodes[file]
+	if !ok {
+		content := f.contents[file]
+		if DetectFormat(file, "", content) != FormatTOML {
+			decoder := yamlv3.NewDecoder(bytes.NewReader(content))
+			for {
+				var doc yamlv3.Node
+				if err := decoder.Decode(&doc); err != nil {
+					break
+				}
+				var node *yamlv3.Node
+				if len(doc.Content) > 0 {
+					node = doc.Content[0]
+				}
+				nodes = append(nodes, node)
+			}
+		}
+		f.nodes[file] = nodes
+	}
+	if document >= len(nodes) {
+		return nil
+	}
+	return nodes[document]
+}
+
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	for node != nil && node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			for value.Kind == yamlv3.AliasNode {
+				value = value.Alias
+			}
+			return value
+		}
+	}
+	return nil
+}
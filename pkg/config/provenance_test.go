@@ -0,0 +1,114 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProvenanceTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   int
+	}{
+		{name: "no items", config: "k3s:\n  redirectBase: https://github.com/\n"},
+		{name: "items", config: "k3s:\n  channels:\n  - name: stable\n  - name: latest\n  releases:\n  - version: v1.30.4+k3s1\n", want: 3},
+		{name: "single config", config: "channels:\n- name: stable\nappDefaults:\n- appName: rancher\n", want: 2},
+		{name: "keys with slashes and tildes", config: "k3s/~1:\n  channels:\n  - name: stable\n", want: 1},
+		{name: "documents", config: "k3s:\n  channels:\n  - name: stable\n---\nk3s:\n  channels:\n  - name: latest\n", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseSource([]byte(tt.config), FormatYAML, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			annotated := deepCopy(doc)
+
+			notes := takeProvenance(doc)
+			if len(notes) != tt.want {
+				t.Errorf("took %d notes, want %d", len(notes), tt.want)
+			}
+			if data, _ := json.Marshal(doc); strings.Contains(string(data), provenanceKey) {
+				t.Errorf("provenance left in %s", data)
+			}
+			notes.restore(doc)
+			if !reflect.DeepEqual(doc, annotated) {
+				t.Errorf("restored %v, want %v", doc, annotated)
+			}
+		})
+	}
+}
+
+// TestLoadConfigProvenanceNotDigested checks that assembled configs are
+// digested and cached without the provenance of their items, which is
+// still served.
+func TestLoadConfigProvenanceNotDigested(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "stable.yaml"), "- name: stable\n  latest: v1.30.4+k3s1\n")
+	overlay := writeFile(t, filepath.Join(dir, "overlay.yaml"), "k3s:\n  channels:\n  - name: stable\n    latest: v1.31.0+k3s1\n")
+
+	tests := []struct {
+		name     string
+		config   string
+		overlays []Source
+		// want is the file and path of the provenance of the channel.
+		want string
+	}{
+		{
+			name:   "include",
+			config: "k3s:\n  channels:\n  - $include: stable.yaml\n",
+			want:   "stable.yaml [0]",
+		},
+		{
+			name:     "overlay",
+			config:   "k3s:\n  channels:\n  - name: stable\n    latest: v1.30.4+k3s1\n",
+			overlays: []Source{&FileSource{Path: overlay}},
+			want:     "channels.yaml k3s.channels[0] overlaid by file://" + overlay,
+		},
+		{
+			name:   "documents",
+			config: "k3s:\n  channels: []\n---\nk3s:\n  channels:\n  - name: stable\n    latest: v1.30.4+k3s1\n",
+			want:   "channels.yaml k3s.channels[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &FileSource{Path: writeFile(t, filepath.Join(dir, "channels.yaml"), tt.config)}
+			c := newConfig("k3s", []Source{source}, Options{CacheDir: t.TempDir(), Overlays: tt.overlays})
+			if _, err := c.loadConfig(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			entry, err := c.readCache()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(entry.Content), provenanceKey) {
+				t.Errorf("provenance cached in %s", entry.Content)
+			}
+			if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(entry.Content)); c.Status().Digest != digest {
+				t.Errorf("digest %s, want %s of the cached config", c.Status().Digest, digest)
+			}
+
+			channels := c.ChannelsConfig().Channels
+			if len(channels) != 1 {
+				t.Fatalf("served channels %v, want one", channels)
+			}
+			p := channels[0].Provenance
+			got := strings.TrimPrefix(p.File, "file://"+dir+"/") + " " + p.Path
+			if len(p.OverlaidBy) > 0 {
+				got += " overlaid by " + strings.Join(p.OverlaidBy, ", ")
+			}
+			if got != tt.want {
+				t.Errorf("provenance %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rancher/channelserver/pkg/model"
+)
+
+// ValidationMode is how a config that does not match the schema is handled.
+type ValidationMode string
+
+const (
+	// ValidationStrict rejects the config. It is the default.
+	ValidationStrict ValidationMode = "strict"
+	// ValidationWarn logs the problems and applies the config anyway.
+	ValidationWarn ValidationMode = "warn"
+)
+
+// ParseValidationMode returns the validation mode named by value, which
+// defaults to ValidationStrict if empty.
+func ParseValidationMode(value string) (ValidationMode, error) {
+	switch mode := ValidationMode(value); mode {
+	case "":
+		return ValidationStrict, nil
+	case ValidationStrict, ValidationWarn:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown validation mode %q, must be %s or %s", value, ValidationStrict, ValidationWarn)
+}
+
+// ValidationError is a value of a config that does not match the schema.
+type ValidationError struct {
+	// Path is the dotted path of the value, such as k3s.channels[0].name.
+	Path    string
+	Message string
+	// Provenance is where the channel, release or app default holding the
+	// value was defined, if it is known.
+	Provenance *model.Provenance
+}
+
+func (e *ValidationError) Error() string {
+	if e.Provenance != nil {
+		return fmt.Sprintf("%s: %s (%s)", e.Path, e.Message, e.Provenance)
+	}
+	return e.Path + ": " + e.Message
+}
+
+// schema is the subset of JSON Schema that model.Schema is written in.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Defs                 map[string]*schema `json:"$defs"`
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Required             []string           `json:"required"`
+	Enum                 []interface{}      `json:"enum"`
+
+	// reject is set for the false schema, which no value matches.
+	reject bool
+}
+
+func (s *schema) UnmarshalJSON(data []byte) error {
+	var allow bool
+	if err := json.Unmarshal(data, &allow); err == nil {
+		s.reject = !allow
+		return nil
+	}
+	type plain schema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// schemaTypes are the types a value may have, given as a single name or a
+// list of names.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = []string{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+var loadSchema = sync.OnceValues(func() (*schema, error) {
+	root := &schema{}
+	if err := json.Unmarshal(model.Schema, root); err != nil {
+		return nil, fmt.Errorf("failed to parse config schema: %w", err)
+	}
+	return root, nil
+})
+
+// validateConfig checks the config of a subkey against the schema, returning
+// a ValidationError for each value that does not match, joined. Paths start
+// with path, usually the subkey. locate returns where an item with the
+// recorded provenance was defined.
+func validateConfig(config interface{}, path string, locate func(recorded interface{}) *model.Provenance) error {
+	root, err := loadSchema()
+	if err != nil {
+		return err
+	}
+	v := &validator{root: root, locate: locate}
+	v.validate(root.Defs["config"], config, path)
+	return errors.Join(v.errs...)
+}
+
+type validator struct {
+	root   *schema
+	locate func(recorded interface{}) *model.Provenance
+	// item is the provenance recorded for the item being validated.
+	item interface{}
+	errs []error
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "config"
+	}
+	err := &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	if v.item != nil && v.locate != nil {
+		err.Provenance = v.locate(v.item)
+	}
+	v.errs = append(v.errs, err)
+}
+
+func (v *validator) validate(s *schema, node interface{}, path string) {
+	if s == nil {
+		return
+	}
+	if s.Ref != "" {
+		s = v.root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		if s == nil {
+			return
+		}
+	}
+	if s.reject {
+		v.fail(path, "not allowed")
+		return
+	}
+	if len(s.Type) > 0 && !matchesType(s.Type, node) {
+		names := make([]string, len(s.Type))
+		for i, name := range s.Type {
+			names[i] = schemaTypeNames[name]
+		}
+		v.fail(path, "must be %s, found %s", strings.Join(names, " or "), typeName(node))
+		return
+	}
+	if len(s.Enum) > 0 && !matchesEnum(s.Enum, node) {
+		v.fail(path, "must be one of %s, found %v", enumNames(s.Enum), node)
+	}
+
+	switch node := node.(type) {
+	case map[string]interface{}:
+		if recorded, ok := node[provenanceKey]; ok {
+			defer func(item interface{}) {
+				v.item = item
+			}(v.item)
+			v.item = recorded
+		}
+		for _, name := range s.Required {
+			if _, ok := node[name]; !ok {
+				v.fail(path, "missing required field %s", name)
+			}
+		}
+		keys := make([]string, 0, len(node))
+		for key := range node {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == provenanceKey {
+				continue
+			}
+			if property, ok := s.Properties[key]; ok {
+				v.validate(property, node[key], joinField(path, key))
+				continue
+			}
+			if s.AdditionalProperties != nil && s.AdditionalProperties.reject {
+				v.fail(joinField(path, key), "unknown field%s", suggest(key, s.Properties))
+				continue
+			}
+			v.validate(s.AdditionalProperties, node[key], joinField(path, key))
+		}
+	case []interface{}:
+		for i, item := range node {
+			v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func matchesType(types []string, node interface{}) bool {
+	for _, name := range types {
+		switch value := node.(type) {
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case float64:
+			if name == "number" || name == "integer" && value == math.Trunc(value) {
+				return true
+			}
+		case nil:
+			if name == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaTypeNames describe the JSON Schema types in the words of typeName.
+var schemaTypeNames = map[string]string{
+	"object":  "a mapping",
+	"array":   "a list",
+	"string":  "a string",
+	"boolean": "a boolean",
+	"number":  "a number",
+	"integer": "an integer",
+	"null":    "null",
+}
+
+func typeName(node interface{}) string {
+	switch node.(type) {
+	case map[string]interface{}:
+		return "a mapping"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+func matchesEnum(values []interface{}, node interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, node) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumNames(values []interface{}) string {
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = fmt.Sprint(value)
+	}
+	return strings.Join(names, ", ")
+}
+
+// suggest returns a hint naming the known field closest to key, if one is
+// close enough to be a likely typo.
+func suggest(key string, properties map[string]*schema) string {
+	best, distance := "", 3
+	for name := range properties {
+		d := editDistance(strings.ToLower(key), strings.ToLower(name))
+		if d < distance || d == distance && name < best {
+			best, distance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(prev[j]+1, current[j-1]+1, prev[j-1]+cost)
+		}
+		prev = current
+	}
+	return prev[len(b)]
+}
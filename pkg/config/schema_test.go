@@ -0,0 +1,176 @@
+package config
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// schemaTypesOf are the schema types of the kinds of the model types.
+var schemaTypesOf = map[reflect.Kind]string{
+	reflect.String: "string",
+	reflect.Bool:   "boolean",
+	reflect.Int:    "integer",
+	reflect.Int64:  "integer",
+	reflect.Slice:  "array",
+	reflect.Map:    "object",
+	reflect.Struct: "object",
+}
+
+// directives are the properties of the schema that are not fields of the
+// model types, as they are handled while the config is assembled.
+var directives = []string{versionKey, includeKey, "$patch"}
+
+// TestSchemaMatchesModel walks the model types a config is decoded into
+// along with the schema, so that a field added to either is added to both.
+func TestSchemaMatchesModel(t *testing.T) {
+	root, err := loadSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var walk func(s *schema, typ reflect.Type, path string)
+	walk = func(s *schema, typ reflect.Type, path string) {
+		if s.Ref != "" {
+			s = root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		}
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if typ.Kind() == reflect.Interface {
+			if len(s.Type) > 0 {
+				t.Errorf("%s: any value in the model, %v in the schema", path, s.Type)
+			}
+			return
+		}
+		if want := schemaTypesOf[typ.Kind()]; !slices.Equal(s.Type, []string{want}) {
+			t.Errorf("%s: %s in the model, %v in the schema", path, want, s.Type)
+			return
+		}
+
+		switch typ.Kind() {
+		case reflect.Slice:
+			walk(s.Items, typ.Elem(), path+"[]")
+		case reflect.Map:
+			walk(s.AdditionalProperties, typ.Elem(), path+".*")
+		case reflect.Struct:
+			fields := map[string]bool{}
+			for i := range typ.NumField() {
+				field := typ.Field(i)
+				name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+				if !field.IsExported() || name == "-" {
+					continue
+				}
+				fields[name] = true
+				property, ok := s.Properties[name]
+				if !ok {
+					t.Errorf("%s: field %s of the model is not in the schema", path, name)
+					continue
+				}
+				walk(property, field.Type, joinField(path, name))
+			}
+			for name := range s.Properties {
+				if !fields[name] && !slices.Contains(directives, name) {
+					t.Errorf("%s: property %s of the schema is not in the model", path, name)
+				}
+			}
+		}
+	}
+	walk(root.Defs["config"], reflect.TypeFor[Document](), "config")
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		// want are the problems found, without provenance as the
+		// config is not loaded from a source.
+		want []string
+	}{
+		{name: "valid", config: "k3s:\n  redirectBase: https://github.com/\n  channels:\n  - name: stable\n    latest: v1.30.4+k3s1\n    $patch: replace\n"},
+		{name: "unknown field", config: "k3s:\n  channels:\n  - name: stable\n    lates: v1.30.4+k3s1\n", want: []string{"k3s.channels[0].lates: unknown field, did you mean latest?"}},
+		{name: "wrong type", config: "k3s:\n  releases:\n  - version: 1.30\n", want: []string{"k3s.releases[0].version: must be a string, found a number"}},
+		{name: "missing required field", config: "k3s:\n  appDefaults:\n  - defaults: []\n", want: []string{"k3s.appDefaults[0]: missing required field appName"}},
+		{name: "not in the enum", config: "k3s:\n  channels:\n  - name: stable\n    $patch: merge\n", want: []string{"k3s.channels[0].$patch: must be one of delete, replace, found merge"}},
+		{
+			name:   "several problems",
+			config: "k3s:\n  redirectbase: https://github.com/\n  channels:\n  - name: stable\n    latest: [v1.30.4+k3s1]\n",
+			want: []string{
+				"k3s.channels[0].latest: must be a string, found a list",
+				"k3s.redirectbase: unknown field, did you mean redirectBase?",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := ParseDocument([]byte(tt.config), "k3s")
+			if err == nil {
+				err = document.Validate()
+			}
+			var got []string
+			if err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got problems %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	const invalid = "k3s:\n  channels:\n  - name: stable\n    latest: v1.30.4+k3s1\n    lates: v1.31.0+k3s1\n"
+
+	tests := []struct {
+		name       string
+		validation ValidationMode
+		wantErr    string
+		want       []string
+	}{
+		{name: "strict", validation: ValidationStrict, wantErr: "invalid config: k3s.channels[0].lates: unknown field"},
+		{name: "default", wantErr: "invalid config"},
+		{name: "warn", validation: ValidationWarn, want: []string{"stable=v1.30.4+k3s1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newTestSource("test://channels.yaml", invalid)
+			c := newConfig("k3s", []Source{source}, Options{Validation: tt.validation})
+			_, err := c.loadConfig(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := channelNames(c); !slices.Equal(got, tt.want) {
+				t.Errorf("served channels %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseValidationMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ValidationMode
+		wantErr bool
+	}{
+		{value: "", want: ValidationStrict},
+		{value: "strict", want: ValidationStrict},
+		{value: "warn", want: ValidationWarn},
+		{value: "lenient", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseValidationMode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultSignatureSuffix is appended to the location of content to find its
+// detached signature.
+const DefaultSignatureSuffix = ".sig"
+
+// SiblingSource is implemented by sources that can locate a file stored next
+// to their content, such as a detached signature. The returned source reads
+// the same revision as the last fetched content where the source has
+// revisions.
+type SiblingSource interface {
+	Source
+	Sibling(suffix string) (Source, error)
+}
+
+// Verifier checks detached signatures of channel configuration against a set
+// of trusted public keys. Signatures are made over the raw content, with
+// ed25519 keys, or with ECDSA or RSA keys over its SHA-256 digest as done by
+// cosign sign-blob. The signature file holds the signature either raw or
+// base64 encoded.
+type Verifier struct {
+	Keys   []crypto.PublicKey
+	Suffix string
+}
+
+// LoadVerifier reads the PEM encoded public keys in paths.
+func LoadVerifier(paths []string, suffix string) (*Verifier, error) {
+	verifier := &Verifier{Suffix: suffix}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public keys in %s: %w", path, err)
+		}
+		verifier.Keys = append(verifier.Keys, keys...)
+	}
+	return verifier, nil
+}
+
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM encoded public keys found")
+	}
+	return keys, nil
+}
+
+// Verify checks that signature is a valid signature of content by any of
+// the trusted keys.
+func (v *Verifier) Verify(content, signature []byte) error {
+	// Only base64 encoded signatures are trimmed, as raw ones may end in
+	// bytes that are whitespace.
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature))); err == nil {
+		signature = decoded
+	}
+	digest := sha256.Sum256(content)
+
+	for _, key := range v.Keys {
+		switch key := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, content, signature) {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], signature) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		}
+	}
+	return errors.New("signature does not match any trusted key")
+}
+
+// verifySource fetches the detached signature stored next to the content of
+// source and verifies it.
+func (v *Verifier) verifySource(ctx context.Context, source Source, content []byte) error {
+	sibling, ok := source.(SiblingSource)
+	if !ok {
+		return fmt.Errorf("signatures cannot be located for %s", source.URL())
+	}
+
+	suffix := v.Suffix
+	if suffix == "" {
+		suffix = DefaultSignatureSuffix
+	}
+	signatureSource, err := sibling.Sibling(suffix)
+	if err != nil {
+		return err
+	}
+	signature, err := signatureSource.Fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get signature from %s: %w", signatureSource.URL(), err)
+	}
+	if err := v.Verify(content, signature); err != nil {
+		return fmt.Errorf("invalid signature %s: %w", signatureSource.URL(), err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+)
+
+// testSigner signs content like cosign sign-blob with a generated key.
+type testSigner struct {
+	public crypto.PublicKey
+	sign   func(content []byte) []byte
+}
+
+func newTestSigners(t *testing.T) map[string]testSigner {
+	t.Helper()
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]testSigner{
+		"ed25519": {public: edPublic, sign: func(content []byte) []byte {
+			return ed25519.Sign(edPrivate, content)
+		}},
+		"ecdsa": {public: &ecKey.PublicKey, sign: func(content []byte) []byte {
+			digest := sha256.Sum256(content)
+			signature, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		}},
+		"rsa": {public: &rsaKey.PublicKey, sign: func(content []byte) []byte {
+			digest := sha256.Sum256(content)
+			signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		}},
+	}
+}
+
+// writePublicKey writes key PEM encoded into dir, returning its path.
+func writePublicKey(t *testing.T, dir, name string, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, filepath.Join(dir, name+".pem"), string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+}
+
+func TestVerifierVerify(t *testing.T) {
+	signers := newTestSigners(t)
+	dir := t.TempDir()
+	content := []byte(testConfig)
+
+	for name, signer := range signers {
+		verifier, err := LoadVerifier([]string{writePublicKey(t, dir, name, signer.public)}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := signers["ed25519"]
+		if name == "ed25519" {
+			other = signers["rsa"]
+		}
+		signature := signer.sign(content)
+
+		tests := []struct {
+			name      string
+			content   []byte
+			signature []byte
+			wantErr   bool
+		}{
+			{name: "raw", content: content, signature: signature},
+			{name: "base64", content: content, signature: []byte(base64.StdEncoding.EncodeToString(signature) + "\n")},
+			{name: "changed content", content: append([]byte("# changed\n"), content...), signature: signature, wantErr: true},
+			{name: "other key", content: content, signature: other.sign(content), wantErr: true},
+			{name: "missing", content: content, wantErr: true},
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				if err := verifier.Verify(tt.content, tt.signature); (err != nil) != tt.wantErr {
+					t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadConfigVerifiesSignature(t *testing.T) {
+	signer := newTestSigners(t)["ed25519"]
+	dir := t.TempDir()
+	verifier, err := LoadVerifier([]string{writePublicKey(t, dir, "key", signer.public)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "channels.yaml")
+	c := newConfig("k3s", []Source{&FileSource{Path: path}}, Options{Verifier: verifier})
+
+	changed := testConfig + "  - name: latest\n    latest: v1.32.0+k3s1\n"
+	tests := []struct {
+		name        string
+		content     string
+		signature   []byte
+		wantChanged bool
+		wantErr     bool
+	}{
+		{name: "good signature", content: testConfig, signature: signer.sign([]byte(testConfig)), wantChanged: true},
+		{name: "bad signature", content: changed, signature: signer.sign([]byte(testConfig)), wantErr: true},
+		{name: "missing signature", content: changed, wantErr: true},
+		// The applied content is checked again, as its signature may have
+		// been replaced since it was applied.
+		{name: "bad signature of the applied content", content: testConfig, signature: signer.sign([]byte(changed)), wantErr: true},
+		{name: "missing signature of the applied content", content: testConfig, wantErr: true},
+		{name: "good signature of the applied content", content: testConfig, signature: signer.sign([]byte(testConfig))},
+		{name: "good signature of changed content", content: changed, signature: signer.sign([]byte(changed)), wantChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFile(t, path, tt.content)
+			if tt.signature != nil {
+				writeFile(t, path+DefaultSignatureSuffix, string(tt.signature))
+			} else {
+				removeFile(t, path+DefaultSignatureSuffix)
+			}
+
+			changed, err := c.loadConfig(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+	if got := channelNames(c); len(got) != 3 {
+		t.Errorf("served channels %v, want 3", got)
+	}
+}
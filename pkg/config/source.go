@@ -0,0 +1,263 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Source is a location channel configuration content can be fetched from.
+type Source interface {
+	URL() string
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// ErrNotModified is returned by a ConditionalSource when the content has not
+// changed since it issued the validators passed to it.
+var ErrNotModified = errors.New("not modified")
+
+// FetchMeta is the metadata a source reports for fetched content. ETag and
+// LastModified are passed back to the source as validators on the next fetch.
+// ContentType is the media type of the content, if the source reports one.
+type FetchMeta struct {
+	ETag         string
+	LastModified string
+	ContentType  string
+}
+
+// ConditionalSource is implemented by sources that can avoid transferring
+// content that has not changed since a previous fetch.
+type ConditionalSource interface {
+	Source
+	FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error)
+}
+
+// WatchableSource is implemented by sources that can report changes to their
+// content as they happen. Watch returns once watching has started, and calls
+// changed whenever the content may have changed until ctx is done.
+type WatchableSource interface {
+	Source
+	Watch(ctx context.Context, changed func()) error
+}
+
+// fetch retrieves content from url, using prev as validators if the source
+// supports conditional fetches.
+func fetch(ctx context.Context, url Source, prev FetchMeta) ([]byte, FetchMeta, error) {
+	if conditional, ok := url.(ConditionalSource); ok {
+		return conditional.FetchConditional(ctx, prev)
+	}
+	content, err := url.Fetch(ctx)
+	return content, FetchMeta{}, err
+}
+
+// SourceFactory creates a Source for a URL whose scheme it was registered for.
+type SourceFactory func(u *url.URL) (Source, error)
+
+var (
+	sourceFactoriesMu sync.RWMutex
+	sourceFactories   = map[string]SourceFactory{}
+)
+
+func init() {
+	RegisterSource("file", newFileSource)
+	RegisterSource("http", newHTTPSource)
+	RegisterSource("https", newHTTPSource)
+	RegisterSource("git", newGitSource)
+	RegisterSource("git+file", newGitSource)
+	RegisterSource("git+http", newGitSource)
+	RegisterSource("git+https", newGitSource)
+	RegisterSource("git+ssh", newGitSource)
+	RegisterSource("github", newGitHubSource)
+	RegisterSource("oci", newOCISource)
+	RegisterSource("s3", newS3Source)
+}
+
+// RegisterSource registers a factory for URLs with the given scheme,
+// replacing any factory previously registered for it.
+func RegisterSource(scheme string, factory SourceFactory) {
+	sourceFactoriesMu.Lock()
+	defer sourceFactoriesMu.Unlock()
+	sourceFactories[strings.ToLower(scheme)] = factory
+}
+
+// RegisteredSchemes returns the sorted list of schemes NewSource understands.
+func RegisteredSchemes() []string {
+	sourceFactoriesMu.RLock()
+	defer sourceFactoriesMu.RUnlock()
+	var schemes []string
+	for scheme := range sourceFactories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NewSource returns the Source registered for the scheme of rawURL. A value
+// without a scheme is treated as a local file path.
+func NewSource(rawURL string) (Source, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || isWindowsPath(rawURL) {
+		return &FileSource{Path: rawURL}, nil
+	}
+
+	sourceFactoriesMu.RLock()
+	factory, ok := sourceFactories[strings.ToLower(u.Scheme)]
+	sourceFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported source scheme %q in %s, supported schemes are %v", u.Scheme, rawURL, RegisteredSchemes())
+	}
+	return factory(u)
+}
+
+// isWindowsPath reports whether s looks like a path with a drive letter,
+// which url.Parse would otherwise read as a single letter scheme.
+func isWindowsPath(s string) bool {
+	return len(s) > 2 && s[1] == ':' && (s[2] == '\\' || s[2] == '/')
+}
+
+func sourceURLs(urls []Source) []string {
+	result := make([]string, 0, len(urls))
+	for _, url := range urls {
+		result = append(result, url.URL())
+	}
+	return result
+}
+
+// StringSource is a Source identified only by its URL. Fetches, watches and
+// locating signatures and included files are delegated to the Source
+// registered for the URL scheme.
+type StringSource string
+
+func (s StringSource) URL() string {
+	return string(s)
+}
+
+func (s StringSource) Fetch(ctx context.Context) ([]byte, error) {
+	src, err := NewSource(string(s))
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource(src)
+	return src.Fetch(ctx)
+}
+
+// FetchConditional fetches with prev as validators if the registered source
+// supports conditional fetches, and fetches unconditionally otherwise.
+func (s StringSource) FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error) {
+	src, err := NewSource(string(s))
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	defer closeSource(src)
+	return fetch(ctx, src, prev)
+}
+
+// Sibling locates a file stored next to the content with the registered
+// source, so that its signature can be verified.
+func (s StringSource) Sibling(suffix string) (Source, error) {
+	src, err := NewSource(string(s))
+	if err != nil {
+		return nil, err
+	}
+	return siblingOf(src, suffix)
+}
+
+// Resolve locates a path relative to the content with the registered
+// source, so that it can include files by relative paths.
+func (s StringSource) Resolve(ref string) (Source, error) {
+	src, err := NewSource(string(s))
+	if err != nil {
+		return nil, err
+	}
+	return resolveFrom(src, ref)
+}
+
+func siblingOf(source Source, suffix string) (Source, error) {
+	sibling, ok := source.(SiblingSource)
+	if !ok {
+		return nil, fmt.Errorf("signatures cannot be located for %s", source.URL())
+	}
+	located, err := sibling.Sibling(suffix)
+	if err != nil {
+		return nil, err
+	}
+	return closeAfterFetch(located), nil
+}
+
+func resolveFrom(source Source, ref string) (Source, error) {
+	resolver, ok := source.(ResolvingSource)
+	if !ok {
+		return nil, fmt.Errorf("relative includes are not supported by %s", source.URL())
+	}
+	resolved, err := resolver.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return closeAfterFetch(resolved), nil
+}
+
+// closeAfterFetch returns source, located from one created for a
+// StringSource, so that it is closed after every fetch if it holds
+// resources between fetches, as nothing else owns it.
+func closeAfterFetch(source Source) Source {
+	if _, ok := source.(io.Closer); !ok {
+		return source
+	}
+	if _, ok := source.(closingSource); ok {
+		return source
+	}
+	return closingSource{source}
+}
+
+// closingSource is a source that is closed after every fetch.
+type closingSource struct {
+	source Source
+}
+
+func (s closingSource) URL() string {
+	return s.source.URL()
+}
+
+func (s closingSource) Fetch(ctx context.Context) ([]byte, error) {
+	defer closeSource(s.source)
+	return s.source.Fetch(ctx)
+}
+
+func (s closingSource) FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error) {
+	defer closeSource(s.source)
+	return fetch(ctx, s.source, prev)
+}
+
+func (s closingSource) Sibling(suffix string) (Source, error) {
+	return siblingOf(s.source, suffix)
+}
+
+func (s closingSource) Resolve(ref string) (Source, error) {
+	return resolveFrom(s.source, ref)
+}
+
+// closeSource closes a source created for a single fetch, if it holds
+// resources between fetches.
+func closeSource(source Source) {
+	if closer, ok := source.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// Watch watches the registered source if it can be watched. Other sources
+// are left to be reloaded on the refresh schedule, without an error.
+func (s StringSource) Watch(ctx context.Context, changed func()) error {
+	src, err := NewSource(string(s))
+	if err != nil {
+		return err
+	}
+	if watchable, ok := src.(WatchableSource); ok {
+		return watchable.Watch(ctx, changed)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// fileDebounce is how long a watched file must be left alone after a change
+// before a reload is triggered, so a write in several steps triggers once.
+const fileDebounce = 250 * time.Millisecond
+
+// FileSource reads channel configuration from the local filesystem.
+type FileSource struct {
+	Path string
+}
+
+func newFileSource(u *url.URL) (Source, error) {
+	path := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		// file://relative/path is not a valid file URL, but is common enough
+		// to treat as a path relative to the working directory.
+		path = u.Host + path
+	}
+	return &FileSource{Path: path}, nil
+}
+
+// URL returns the file URL of the absolute path of the file, so that a
+// file is identified the same way however its path was given.
+func (f *FileSource) URL() string {
+	path, err := filepath.Abs(f.Path)
+	if err != nil {
+		path = f.Path
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// A path with a drive letter is written file:///C:/path.
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func (f *FileSource) Fetch(_ context.Context) ([]byte, error) {
+	return os.ReadFile(f.Path)
+}
+
+func (f *FileSource) Sibling(suffix string) (Source, error) {
+	return &FileSource{Path: f.Path + suffix}, nil
+}
+
+func (f *FileSource) Resolve(ref string) (Source, error) {
+	if filepath.IsAbs(ref) {
+		return &FileSource{Path: ref}, nil
+	}
+	return &FileSource{Path: filepath.Join(filepath.Dir(f.Path), ref)}, nil
+}
+
+// Watch watches the directory of the file rather than the file itself, so
+// that replacing it by a rename, or swapping the symlinks of a mounted
+// ConfigMap, is noticed.
+func (f *FileSource) Watch(ctx context.Context, changed func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := []string{filepath.Dir(f.Path)}
+	names := map[string]bool{filepath.Base(f.Path): true}
+	if target, err := filepath.EvalSymlinks(f.Path); err == nil && target != f.Path {
+		dirs = append(dirs, filepath.Dir(target))
+		names[filepath.Base(target)] = true
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		timer := time.AfterFunc(time.Hour, changed)
+		timer.Stop()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if affects(event, names) {
+					timer.Reset(fileDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logrus.Warnf("Error watching %s: %v", f.Path, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// affects reports whether event may have changed the content of a file with
+// one of the given names. Kubernetes updates mounted ConfigMaps by replacing
+// symlinks named with a ".." prefix, so changes to those are included.
+func affects(event fsnotify.Event, names map[string]bool) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	return names[name] || strings.HasPrefix(name, "..")
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestAffects(t *testing.T) {
+	names := map[string]bool{"channels.yaml": true}
+	tests := []struct {
+		event fsnotify.Event
+		want  bool
+	}{
+		{event: fsnotify.Event{Name: "/etc/config/channels.yaml", Op: fsnotify.Write}, want: true},
+		{event: fsnotify.Event{Name: "/etc/config/channels.yaml", Op: fsnotify.Create}, want: true},
+		{event: fsnotify.Event{Name: "/etc/config/channels.yaml", Op: fsnotify.Rename}, want: true},
+		{event: fsnotify.Event{Name: "/etc/config/channels.yaml", Op: fsnotify.Remove}, want: true},
+		{event: fsnotify.Event{Name: "/etc/config/channels.yaml", Op: fsnotify.Chmod}, want: false},
+		{event: fsnotify.Event{Name: "/etc/config/..data", Op: fsnotify.Create}, want: true},
+		{event: fsnotify.Event{Name: "/etc/config/..2024_01_01_00_00_00.000000000", Op: fsnotify.Remove}, want: true},
+		{event: fsnotify.Event{Name: "/etc/config/other.yaml", Op: fsnotify.Write}, want: false},
+		{event: fsnotify.Event{Name: "/etc/config/.channels.yaml.swp", Op: fsnotify.Write}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.event.String(), func(t *testing.T) {
+			if got := affects(tt.event, names); got != tt.want {
+				t.Errorf("affects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSourceWatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, dir string) string
+		change func(t *testing.T, dir string)
+	}{
+		{
+			name: "write in place",
+			setup: func(t *testing.T, dir string) string {
+				return writeFile(t, filepath.Join(dir, "channels.yaml"), testConfig)
+			},
+			change: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "channels.yaml"), testConfig+"  - name: latest\n    latest: v1.32.0+k3s1\n")
+			},
+		},
+		{
+			name: "replace by rename",
+			setup: func(t *testing.T, dir string) string {
+				return writeFile(t, filepath.Join(dir, "channels.yaml"), testConfig)
+			},
+			change: func(t *testing.T, dir string) {
+				next := writeFile(t, filepath.Join(dir, "channels.yaml.tmp"), testConfig+"  - name: latest\n    latest: v1.32.0+k3s1\n")
+				if err := os.Rename(next, filepath.Join(dir, "channels.yaml")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			// A mounted ConfigMap is updated by pointing the ..data symlink
+			// at a new directory.
+			name: "swap ConfigMap symlinks",
+			setup: func(t *testing.T, dir string) string {
+				writeFile(t, filepath.Join(dir, "..v1", "channels.yaml"), testConfig)
+				symlink(t, "..v1", filepath.Join(dir, "..data"))
+				symlink(t, filepath.Join("..data", "channels.yaml"), filepath.Join(dir, "channels.yaml"))
+				return filepath.Join(dir, "channels.yaml")
+			},
+			change: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "..v2", "channels.yaml"), testConfig+"  - name: latest\n    latest: v1.32.0+k3s1\n")
+				symlink(t, "..v2", filepath.Join(dir, "..data_tmp"))
+				if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := tt.setup(t, dir)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changed := make(chan struct{}, 1)
+			err := (&FileSource{Path: path}).Watch(ctx, func() {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.change(t, dir)
+			select {
+			case <-changed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("change to the file was not reported")
+			}
+		})
+	}
+}
+
+func TestConfigReloadsWatchedFile(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "channels.yaml"), testConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The refresh interval is too long to pick up the change.
+	c, err := StartConfig(ctx, "k3s", &DurationWait{Duration: time.Hour}, []Source{&FileSource{Path: path}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, testConfig+"  - name: latest\n    latest: v1.32.0+k3s1\n")
+
+	deadline := time.After(5 * time.Second)
+	for len(channelNames(c)) != 3 {
+		select {
+		case <-deadline:
+			t.Fatalf("served channels %v after the file changed", channelNames(c))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func symlink(t *testing.T, target, path string) {
+	t.Helper()
+	if err := os.Symlink(target, path); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+}
+
+func removeFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var commitSHA = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// GitSource reads channel configuration from a file in a git repository,
+// using the git command line. The repository is fetched into a private bare
+// repository on every load, so only the requested revision is transferred.
+// Credentials in the remote URL are passed to git by a credential helper
+// rather than on its command line.
+type GitSource struct {
+	// Remote is anything git can fetch from: a URL or a local path.
+	Remote string
+	// Ref is the branch, tag or commit to read the file from, defaulting
+	// to the remote HEAD.
+	Ref string
+	// Path is the path of the file within the repository.
+	Path string
+
+	lock   sync.Mutex
+	repo   *gitRepo
+	commit string
+}
+
+// gitRepo is the private bare repository a GitSource fetches into, shared
+// with the sources of its signatures and included files.
+type gitRepo struct {
+	lock sync.Mutex
+	dir  string
+}
+
+// newGitSource handles git URLs of the form
+// git+https://host/repo.git?ref=main&path=channels.yaml. The "git+" prefix is
+// removed from the scheme to get the remote, so git+file:///srv/repo.git reads
+// a local repository.
+func newGitSource(u *url.URL) (Source, error) {
+	query := u.Query()
+	path := query.Get("path")
+	if path == "" {
+		return nil, fmt.Errorf("git source %s requires a path query parameter", u.Redacted())
+	}
+
+	remote := *u
+	remote.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+	remote.RawQuery = ""
+	source := &GitSource{
+		Remote: remote.String(),
+		Ref:    query.Get("ref"),
+		Path:   strings.TrimPrefix(path, "/"),
+	}
+	// git would read a remote or ref starting with a dash as an option,
+	// such as --upload-pack running a command of the URL's choosing.
+	if strings.HasPrefix(source.Remote, "-") || strings.HasPrefix(source.Ref, "-") {
+		return nil, fmt.Errorf("git source %s: remote and ref must not start with -", u.Redacted())
+	}
+	return source, nil
+}
+
+func (g *GitSource) URL() string {
+	u, err := url.Parse(g.Remote)
+	if err != nil || u.Scheme == "" {
+		u = &url.URL{Scheme: "file", Path: g.Remote}
+	}
+	if u.Scheme != "git" {
+		u.Scheme = "git+" + u.Scheme
+	}
+	query := url.Values{}
+	if g.Ref != "" {
+		query.Set("ref", g.Ref)
+	}
+	query.Set("path", g.Path)
+	u.RawQuery = query.Encode()
+	return u.Redacted()
+}
+
+// Commit returns the SHA of the commit the last fetched content came from.
+func (g *GitSource) Commit() string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.commit
+}
+
+// Sibling reads from the commit the last fetched content came from, so
+// that a signature always matches the content it is checked against. The
+// commit is read from the repository it was fetched into, without fetching
+// it again.
+func (g *GitSource) Sibling(suffix string) (Source, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	ref := g.commit
+	if ref == "" {
+		ref = g.Ref
+	}
+	return &GitSource{
+		Remote: g.Remote,
+		Ref:    ref,
+		Path:   g.Path + suffix,
+		repo:   g.repository(),
+	}, nil
+}
+
+// Resolve reads a path relative to the file from the same commit, so that
+// included files always match the content they are included from.
+func (g *GitSource) Resolve(ref string) (Source, error) {
+	sibling, err := g.Sibling("")
+	if err != nil {
+		return nil, err
+	}
+	sibling.(*GitSource).Path = joinPath(g.Path, ref)
+	return sibling, nil
+}
+
+func (g *GitSource) Fetch(ctx context.Context) ([]byte, error) {
+	content, _, err := g.FetchConditional(ctx, FetchMeta{})
+	return content, err
+}
+
+// FetchConditional uses the commit SHA as ETag, returning ErrNotModified if
+// the ref still points at the commit in prev.
+func (g *GitSource) FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error) {
+	g.lock.Lock()
+	repo := g.repository()
+	g.lock.Unlock()
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+
+	commit, err := g.fetchCommit(ctx, repo)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	g.lock.Lock()
+	g.commit = commit
+	g.lock.Unlock()
+	if commit == prev.ETag {
+		return nil, prev, ErrNotModified
+	}
+
+	content, err := g.git(ctx, repo.dir, nil, "cat-file", "blob", commit+":"+g.Path)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	return content, FetchMeta{ETag: commit}, nil
+}
+
+// Close removes the repository the source fetched into. It is created
+// again by the next fetch.
+func (g *GitSource) Close() error {
+	g.lock.Lock()
+	repo := g.repo
+	g.lock.Unlock()
+	if repo == nil {
+		return nil
+	}
+
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	if repo.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(repo.dir)
+	repo.dir = ""
+	return err
+}
+
+// repository returns the repository the source fetches into. It must be
+// called while holding g.lock.
+func (g *GitSource) repository() *gitRepo {
+	if g.repo == nil {
+		g.repo = &gitRepo{}
+	}
+	return g.repo
+}
+
+// fetchCommit fetches Ref from the remote into repo, unless it is a commit
+// that was fetched before, and returns the SHA of its commit. It must be
+// called while holding repo.lock.
+func (g *GitSource) fetchCommit(ctx context.Context, repo *gitRepo) (string, error) {
+	if repo.dir == "" {
+		dir, err := os.MkdirTemp("", "channelserver-git-")
+		if err != nil {
+			return "", err
+		}
+		if _, err := g.git(ctx, dir, nil, "init", "--quiet", "--bare"); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		repo.dir = dir
+	}
+
+	ref := g.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if commitSHA.MatchString(ref) {
+		if commit, err := g.git(ctx, repo.dir, nil, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}"); err == nil {
+			return strings.TrimSpace(string(commit)), nil
+		}
+	}
+
+	remote, env := g.remote()
+	if _, err := g.git(ctx, repo.dir, env, "fetch", "--quiet", "--force", "--no-tags", "--depth=1", "--end-of-options", remote, ref); err != nil {
+		if !commitSHA.MatchString(ref) {
+			return "", err
+		}
+		// Not every server allows fetching a commit by SHA, so fall back to
+		// fetching the tips of all branches and tags and looking for it
+		// there.
+		if _, err := g.git(ctx, repo.dir, env, "fetch", "--quiet", "--force", "--depth=1", "--end-of-options", remote, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"); err != nil {
+			return "", err
+		}
+	} else {
+		ref = "FETCH_HEAD"
+	}
+
+	commit, err := g.git(ctx, repo.dir, nil, "rev-parse", "--verify", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(commit)), nil
+}
+
+// gitCredentialHelper answers the requests git makes for credentials with
+// those in its environment.
+const gitCredentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$CHANNELSERVER_GIT_USERNAME" "$CHANNELSERVER_GIT_PASSWORD"; }; f`
+
+// remote returns the remote to fetch from without the credentials in its
+// URL, and the environment that has git read them from a credential helper
+// instead, so that they are not visible in the process list.
+func (g *GitSource) remote() (string, []string) {
+	u, err := url.Parse(g.Remote)
+	if err != nil || u.User == nil {
+		return g.Remote, nil
+	}
+	password, _ := u.User.Password()
+	env := []string{
+		"GIT_CONFIG_COUNT=2",
+		// An empty helper drops those configured for the user.
+		"GIT_CONFIG_KEY_0=credential.helper",
+		"GIT_CONFIG_VALUE_0=",
+		"GIT_CONFIG_KEY_1=credential.helper",
+		"GIT_CONFIG_VALUE_1=" + gitCredentialHelper,
+		"CHANNELSERVER_GIT_USERNAME=" + u.User.Username(),
+		"CHANNELSERVER_GIT_PASSWORD=" + password,
+	}
+	u.User = nil
+	return u.String(), env
+}
+
+// git runs git on the repository in dir, with env added to its environment.
+func (g *GitSource) git(ctx context.Context, dir string, env []string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--git-dir", dir}, args...)...)
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v67/github"
+)
+
+// GitHubSource reads channel configuration from a file in a GitHub
+// repository through the contents API, so private repositories can be read
+// with a token.
+type GitHubSource struct {
+	Owner string
+	Repo  string
+	Path  string
+	// Ref is the branch, tag or commit to read, defaulting to the default
+	// branch of the repository.
+	Ref string
+	// APIURL is the API URL of a GitHub Enterprise instance.
+	APIURL string
+	// Token authenticates to GitHub.
+	Token string
+	// Client makes the requests, defaulting to a client with a 5 second
+	// timeout.
+	Client *http.Client
+	// MaxBodySize limits the size of fetched content, if set.
+	MaxBodySize int64
+	// NewClient creates the client used for requests from Client, defaulting
+	// to one using Token and APIURL. A Config sets it to use its GitHub token
+	// and the API URL of the configuration it serves when those are unset.
+	NewClient func(client *http.Client, token, apiURL string) (*github.Client, error)
+}
+
+// newGitHubSource handles URLs of the form github://owner/repo/path@ref,
+// with an optional api query parameter for GitHub Enterprise.
+func newGitHubSource(u *url.URL) (Source, error) {
+	repo, path, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if u.Host == "" || repo == "" || path == "" {
+		return nil, fmt.Errorf("GitHub source %s must be of the form github://owner/repo/path@ref", u.Redacted())
+	}
+
+	source := &GitHubSource{
+		Owner:  u.Host,
+		Repo:   repo,
+		Path:   path,
+		APIURL: u.Query().Get("api"),
+	}
+	if i := strings.LastIndex(path, "@"); i >= 0 {
+		source.Path = path[:i]
+		source.Ref = path[i+1:]
+	}
+	return source, nil
+}
+
+func (g *GitHubSource) URL() string {
+	u := "github://" + g.Owner + "/" + g.Repo + "/" + g.Path
+	if g.Ref != "" {
+		u += "@" + g.Ref
+	}
+	if g.APIURL != "" {
+		u += "?api=" + url.QueryEscape(g.APIURL)
+	}
+	return u
+}
+
+func (g *GitHubSource) Sibling(suffix string) (Source, error) {
+	sibling := *g
+	sibling.Path += suffix
+	return &sibling, nil
+}
+
+func (g *GitHubSource) Resolve(ref string) (Source, error) {
+	resolved := *g
+	resolved.Path = joinPath(g.Path, ref)
+	return &resolved, nil
+}
+
+func (g *GitHubSource) Fetch(ctx context.Context) ([]byte, error) {
+	content, _, err := g.FetchConditional(ctx, FetchMeta{})
+	return content, err
+}
+
+// FetchConditional sends the ETag in prev as If-None-Match, returning
+// ErrNotModified if the file has not changed. Conditional requests that are
+// not modified do not count against the GitHub rate limit.
+func (g *GitHubSource) FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error) {
+	newClient := g.NewClient
+	if newClient == nil {
+		newClient = newGHClient
+	}
+	client, err := newClient(g.Client, g.Token, g.APIURL)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+
+	path := fmt.Sprintf("repos/%s/%s/contents/%s", url.PathEscape(g.Owner), url.PathEscape(g.Repo), escapePath(g.Path))
+	if g.Ref != "" {
+		path += "?ref=" + url.QueryEscape(g.Ref)
+	}
+	req, err := client.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	// The raw media type returns the file itself, which unlike the JSON
+	// representation works for files larger than 1MB.
+	req.Header.Set("Accept", "application/vnd.github.raw+json")
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+
+	resp, err := client.BareDo(ctx, req)
+	if resp != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, prev, ErrNotModified
+	}
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	defer resp.Body.Close()
+	content, err := readBody(resp.Response, g.MaxBodySize)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	return content, FetchMeta{ETag: resp.Header.Get("ETag")}, nil
+}
+
+func escapePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
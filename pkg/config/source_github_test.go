@@ -0,0 +1,109 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNewGitHubSource(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    GitHubSource
+		wantErr bool
+	}{
+		{url: "github://rancher/config/channels.yaml", want: GitHubSource{Owner: "rancher", Repo: "config", Path: "channels.yaml"}},
+		{url: "github://rancher/config/k3s/channels.yaml@v1.2.0", want: GitHubSource{Owner: "rancher", Repo: "config", Path: "k3s/channels.yaml", Ref: "v1.2.0"}},
+		{url: "github://rancher/config/channels.yaml@release/v2?api=https%3A%2F%2Fghe.example.com", want: GitHubSource{Owner: "rancher", Repo: "config", Path: "channels.yaml", Ref: "release/v2", APIURL: "https://ghe.example.com"}},
+		{url: "github://rancher/config", wantErr: true},
+		{url: "github:///config/channels.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			source, err := NewSource(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := *source.(*GitHubSource); got.Owner != tt.want.Owner || got.Repo != tt.want.Repo || got.Path != tt.want.Path || got.Ref != tt.want.Ref || got.APIURL != tt.want.APIURL {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got := source.URL(); got != tt.url {
+				t.Errorf("URL() = %s, want %s", got, tt.url)
+			}
+		})
+	}
+}
+
+func TestGitHubSourceFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Accept") != "application/vnd.github.raw+json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.EscapedPath() != "/api/v3/repos/rancher/config/contents/k3s/channels%20v1.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		etag := `"` + r.URL.Query().Get("ref") + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(testConfig))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		ref      string
+		token    string
+		prev     FetchMeta
+		wantETag string
+		wantErr  error
+	}{
+		{name: "default branch", path: "k3s/channels v1.yaml", token: "token", wantETag: `""`},
+		{name: "ref", path: "k3s/channels v1.yaml", ref: "release/v2", token: "token", wantETag: `"release/v2"`},
+		{name: "not modified", path: "k3s/channels v1.yaml", ref: "main", token: "token", prev: FetchMeta{ETag: `"main"`}, wantErr: ErrNotModified},
+		{name: "missing file", path: "k3s/missing.yaml", token: "token", wantErr: errors.New("404")},
+		{name: "without token", path: "k3s/channels v1.yaml", wantErr: errors.New("401")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &GitHubSource{Owner: "rancher", Repo: "config", Path: tt.path, Ref: tt.ref, APIURL: server.URL, Token: tt.token}
+			content, meta, err := source.FetchConditional(context.Background(), tt.prev)
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error())) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != testConfig || meta.ETag != tt.wantETag {
+				t.Errorf("fetched %q with ETag %s, want ETag %s", content, meta.ETag, tt.wantETag)
+			}
+		})
+	}
+
+	// The ref is kept when locating a signature next to the file.
+	sibling, err := (&GitHubSource{Owner: "rancher", Repo: "config", Path: "channels.yaml", Ref: "v1", APIURL: server.URL}).Sibling(".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sibling.URL(), "github://rancher/config/channels.yaml.sig@v1?api="+url.QueryEscape(server.URL); got != want {
+		t.Errorf("sibling %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,275 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const defaultHTTPTimeout = time.Second * 5
+
+var (
+	httpClient = &http.Client{
+		Timeout: defaultHTTPTimeout,
+	}
+)
+
+// HTTPOptions configure the requests a source makes over HTTP.
+type HTTPOptions struct {
+	// Timeout of each request, defaulting to 5 seconds.
+	Timeout time.Duration
+	// CAFile is a PEM bundle of certificate authorities trusted in addition
+	// to the system ones.
+	CAFile string
+	// CertFile and KeyFile are a client certificate and key for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// Proxy is the URL of a proxy to use instead of the one configured in
+	// the environment.
+	Proxy string
+	// BearerToken, or Username and Password, are sent as the Authorization
+	// header.
+	BearerToken string
+	Username    string
+	Password    string
+	// Headers are added to every request.
+	Headers map[string]string
+	// MaxBodySize limits the size of fetched content, if set.
+	MaxBodySize int64
+}
+
+// Client returns an HTTP client for the transport options.
+func (o *HTTPOptions) Client() (*http.Client, error) {
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = defaultHTTPTimeout
+	}
+	if o.CAFile == "" && o.CertFile == "" && o.KeyFile == "" && o.Proxy == "" {
+		return &http.Client{Timeout: timeout}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	if o.Proxy != "" {
+		proxy, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %w", o.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// header returns the request headers for the request options.
+func (o *HTTPOptions) header() http.Header {
+	header := http.Header{}
+	for name, value := range o.Headers {
+		header.Set(name, value)
+	}
+	if o.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+o.BearerToken)
+	} else if o.Username != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(o.Username+":"+o.Password)))
+	}
+	return header
+}
+
+// NewSourceWithHTTPOptions returns the Source for rawURL like NewSource,
+// configured to make its requests with opts. Request headers and
+// authorization only apply to HTTP sources, OCI, S3 and GitHub sources use
+// the client and the body size limit, and other sources are returned as
+// they are.
+func NewSourceWithHTTPOptions(rawURL string, opts HTTPOptions) (Source, error) {
+	source, err := NewSource(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var client *http.Client
+	switch source.(type) {
+	case *HTTPSource, *OCISource, *S3Source, *GitHubSource:
+		client, err = opts.Client()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure HTTP client for %s: %w", source.URL(), err)
+		}
+	}
+
+	switch source := source.(type) {
+	case *HTTPSource:
+		source.Client = client
+		source.Header = opts.header()
+		source.MaxBodySize = opts.MaxBodySize
+	case *OCISource:
+		source.Client = client
+		source.MaxBodySize = opts.MaxBodySize
+	case *S3Source:
+		source.Client = client
+		source.MaxBodySize = opts.MaxBodySize
+	case *GitHubSource:
+		source.Client = client
+		source.MaxBodySize = opts.MaxBodySize
+	}
+	return source, nil
+}
+
+// HTTPSource fetches channel configuration with an HTTP GET request.
+type HTTPSource struct {
+	Address string
+	Client  *http.Client
+	// Header is added to every request.
+	Header http.Header
+	// MaxBodySize limits the size of fetched content, if set.
+	MaxBodySize int64
+}
+
+func newHTTPSource(u *url.URL) (Source, error) {
+	return &HTTPSource{Address: u.String()}, nil
+}
+
+func (h *HTTPSource) URL() string {
+	u, err := url.Parse(h.Address)
+	if err != nil {
+		return h.Address
+	}
+	return u.Redacted()
+}
+
+func (h *HTTPSource) Fetch(ctx context.Context) ([]byte, error) {
+	content, _, err := h.FetchConditional(ctx, FetchMeta{})
+	return content, err
+}
+
+func (h *HTTPSource) Sibling(suffix string) (Source, error) {
+	u, err := url.Parse(h.Address)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += suffix
+	u.RawPath = ""
+	return &HTTPSource{
+		Address:     u.String(),
+		Client:      h.Client,
+		Header:      h.Header,
+		MaxBodySize: h.MaxBodySize,
+	}, nil
+}
+
+// Resolve resolves ref as a URL reference against the address. The headers
+// are only sent to the same host, so credentials are not leaked to others.
+func (h *HTTPSource) Resolve(ref string) (Source, error) {
+	base, err := url.Parse(h.Address)
+	if err != nil {
+		return nil, err
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	resolved := &HTTPSource{
+		Address:     u.String(),
+		Client:      h.Client,
+		MaxBodySize: h.MaxBodySize,
+	}
+	if u.Host == base.Host {
+		resolved.Header = h.Header
+	}
+	return resolved, nil
+}
+
+// FetchConditional sends prev as If-None-Match and If-Modified-Since headers,
+// returning ErrNotModified if the server responds with 304 Not Modified.
+func (h *HTTPSource) FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.Address, nil)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	for name, values := range h.Header {
+		req.Header[name] = values
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, prev, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, FetchMeta{}, fmt.Errorf("status %v", resp.Status)
+	}
+
+	content, err := readBody(resp, h.MaxBodySize)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	return content, FetchMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+var errBodyTooLarge = errors.New("response body too large")
+
+// readBody reads the body of resp, failing if it is larger than limit bytes.
+func readBody(resp *http.Response, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(resp.Body)
+	}
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", errBodyTooLarge, resp.ContentLength, limit)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("%w: exceeds the limit of %d bytes", errBodyTooLarge, limit)
+	}
+	return content, nil
+}
+
+func (h *HTTPSource) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return httpClient
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		limit         int64
+		wantErr       bool
+	}{
+		{name: "no limit", body: "channels: []", contentLength: -1},
+		{name: "within the limit", body: "channels: []", contentLength: 12, limit: 12},
+		{name: "declared too large", body: "channels: []", contentLength: 12, limit: 11, wantErr: true},
+		{name: "chunked within the limit", body: "channels: []", contentLength: -1, limit: 12},
+		{name: "chunked too large", body: "channels: []", contentLength: -1, limit: 11, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Body: io.NopCloser(strings.NewReader(tt.body)), ContentLength: tt.contentLength}
+			content, err := readBody(resp, tt.limit)
+			if tt.wantErr {
+				if !errors.Is(err, errBodyTooLarge) {
+					t.Fatalf("expected errBodyTooLarge, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.body {
+				t.Errorf("read %q, want %q", content, tt.body)
+			}
+		})
+	}
+}
+
+func TestNewSourceWithHTTPOptionsMaxBodySize(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	large := strings.Repeat("#", 1024) + "\n" + testConfig
+
+	r := newTestRegistry(t)
+	r.manifest(t, ociManifest{MediaType: ociManifestMediaType, Layers: []ociDescriptor{r.blob("channels.yaml", []byte(large))}}, "v1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Without a declared length the limit applies to what is read.
+		w.(http.Flusher).Flush()
+		w.Write([]byte(large))
+	}))
+	defer server.Close()
+
+	urls := map[string]string{
+		"http":   server.URL + "/channels.yaml",
+		"oci":    "oci://user:s3cr3t@" + strings.TrimPrefix(r.URL, "http://") + "/config/channels:v1",
+		"s3":     "s3://bucket/channels.yaml?endpoint=" + server.URL,
+		"github": "github://rancher/config/channels.yaml?api=" + url.QueryEscape(server.URL),
+	}
+	tests := []struct {
+		limit   int64
+		wantErr bool
+	}{
+		{limit: 0},
+		{limit: int64(len(large))},
+		{limit: 1024, wantErr: true},
+	}
+	for scheme, rawURL := range urls {
+		for _, tt := range tests {
+			source, err := NewSourceWithHTTPOptions(rawURL, HTTPOptions{MaxBodySize: tt.limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := source.Fetch(context.Background())
+			if tt.wantErr {
+				if !errors.Is(err, errBodyTooLarge) {
+					t.Errorf("%s source with a limit of %d: expected errBodyTooLarge, got %v", scheme, tt.limit, err)
+				}
+				continue
+			}
+			if err != nil || string(content) != large {
+				t.Errorf("%s source with a limit of %d: fetched %d bytes: %v", scheme, tt.limit, len(content), err)
+			}
+		}
+	}
+}
+
+func TestNewSourceWithHTTPOptionsTimeout(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	for _, rawURL := range []string{
+		server.URL + "/channels.yaml",
+		"s3://bucket/channels.yaml?endpoint=" + server.URL,
+		"github://rancher/config/channels.yaml?api=" + url.QueryEscape(server.URL),
+	} {
+		t.Run(rawURL, func(t *testing.T) {
+			source, err := NewSourceWithHTTPOptions(rawURL, HTTPOptions{Timeout: 50 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := source.Fetch(ctx); err == nil || ctx.Err() != nil {
+				t.Fatalf("expected the request to time out, got %v", err)
+			}
+		})
+	}
+}
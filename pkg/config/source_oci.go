@@ -0,0 +1,490 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	ociManifestMediaType        = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType           = "application/vnd.oci.image.index.v1+json"
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociTitleAnnotation          = "org.opencontainers.image.title"
+)
+
+// ociManifestAccept are the media types of the manifests and indexes an
+// artifact may be referenced by.
+var ociManifestAccept = strings.Join([]string{
+	ociManifestMediaType,
+	ociIndexMediaType,
+	dockerManifestMediaType,
+	dockerManifestListMediaType,
+}, ", ")
+
+// OCISource reads channel configuration from a layer of an artifact in an
+// OCI distribution registry. If the artifact is referenced by an image index
+// the manifest for the platform of the server is read.
+type OCISource struct {
+	Registry   string
+	Repository string
+	// Tag of the artifact, ignored if Digest is set.
+	Tag string
+	// Digest pins the manifest of the artifact.
+	Digest string
+	// File selects the layer by its title annotation. Without it the only
+	// layer of the artifact is used.
+	File string
+	// Insecure uses plain HTTP to talk to the registry.
+	Insecure bool
+	// Username and Password authenticate to the registry. If unset the
+	// credentials for the registry in the docker config file are used.
+	Username string
+	Password string
+	Client   *http.Client
+	// MaxBodySize limits the size of fetched manifests and layers, if set.
+	MaxBodySize int64
+
+	lock     sync.Mutex
+	token    string
+	manifest string
+}
+
+// ociManifest is an image manifest, or an image index or manifest list
+// that refers to the manifests of the artifact for several platforms.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *ociPlatform      `json:"platform"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+func (m *ociManifest) isIndex() bool {
+	return m.MediaType == ociIndexMediaType || m.MediaType == dockerManifestListMediaType ||
+		(m.MediaType == "" && len(m.Manifests) > 0)
+}
+
+// newOCISource handles references of the form
+// oci://registry/repository:tag or oci://registry/repository@sha256:digest,
+// with optional file and insecure query parameters. Registries on loopback
+// addresses are accessed over plain HTTP.
+func newOCISource(u *url.URL) (Source, error) {
+	ref := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || ref == "" {
+		return nil, fmt.Errorf("OCI source %s must be of the form oci://registry/repository:tag", u.Redacted())
+	}
+
+	source := &OCISource{
+		Registry: u.Host,
+		File:     u.Query().Get("file"),
+		Insecure: u.Query().Get("insecure") == "true" || isLoopback(u.Hostname()),
+	}
+	source.Repository, source.Digest, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(source.Repository, ":"); i > strings.LastIndex(source.Repository, "/") {
+		source.Tag = source.Repository[i+1:]
+		source.Repository = source.Repository[:i]
+	}
+	if source.Tag == "" && source.Digest == "" {
+		source.Tag = "latest"
+	}
+	if u.User != nil {
+		source.Username = u.User.Username()
+		source.Password, _ = u.User.Password()
+	}
+	return source, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (o *OCISource) URL() string {
+	ref := o.Registry + "/" + o.Repository
+	if o.Tag != "" {
+		ref += ":" + o.Tag
+	}
+	if o.Digest != "" {
+		ref += "@" + o.Digest
+	}
+	query := url.Values{}
+	if o.File != "" {
+		query.Set("file", o.File)
+	}
+	if o.Insecure && !isLoopback(strings.Split(o.Registry, ":")[0]) {
+		query.Set("insecure", "true")
+	}
+	if len(query) > 0 {
+		ref += "?" + query.Encode()
+	}
+	return "oci://" + ref
+}
+
+// Sibling reads another layer of the artifact the last fetched content came
+// from, titled with the suffix appended to the title of the layer holding
+// the content.
+func (o *OCISource) Sibling(suffix string) (Source, error) {
+	if o.File == "" {
+		return nil, fmt.Errorf("%s does not select a layer by file, so no file can be located next to it", o.URL())
+	}
+	o.lock.Lock()
+	digest := o.manifest
+	o.lock.Unlock()
+	if digest == "" {
+		digest = o.Digest
+	}
+	return &OCISource{
+		Registry:    o.Registry,
+		Repository:  o.Repository,
+		Tag:         o.Tag,
+		Digest:      digest,
+		File:        o.File + suffix,
+		Insecure:    o.Insecure,
+		Username:    o.Username,
+		Password:    o.Password,
+		Client:      o.Client,
+		MaxBodySize: o.MaxBodySize,
+	}, nil
+}
+
+// Resolve reads another layer of the same artifact, titled with the path
+// relative to the title of the layer holding the content.
+func (o *OCISource) Resolve(ref string) (Source, error) {
+	sibling, err := o.Sibling("")
+	if err != nil {
+		return nil, err
+	}
+	sibling.(*OCISource).File = joinPath(o.File, ref)
+	return sibling, nil
+}
+
+func (o *OCISource) Fetch(ctx context.Context) ([]byte, error) {
+	content, _, err := o.FetchConditional(ctx, FetchMeta{})
+	return content, err
+}
+
+// FetchConditional uses the digest of the artifact manifest as ETag,
+// returning ErrNotModified if it matches prev.
+func (o *OCISource) FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error) {
+	reference := o.Digest
+	if reference == "" {
+		reference = o.Tag
+	}
+
+	data, err := o.get(ctx, "manifests/"+reference, ociManifestAccept)
+	if err != nil {
+		return nil, FetchMeta{}, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	digest := ociDigest(data)
+	if o.Digest != "" && o.Digest != digest {
+		return nil, FetchMeta{}, fmt.Errorf("manifest digest %s does not match pinned digest %s", digest, o.Digest)
+	}
+	o.lock.Lock()
+	o.manifest = digest
+	o.lock.Unlock()
+	if digest == prev.ETag {
+		return nil, prev, ErrNotModified
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, FetchMeta{}, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if manifest.isIndex() {
+		if manifest, err = o.platformManifest(ctx, manifest); err != nil {
+			return nil, FetchMeta{}, err
+		}
+	}
+	layer, err := o.layer(manifest)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+
+	content, err := o.get(ctx, "blobs/"+layer.Digest, "")
+	if err != nil {
+		return nil, FetchMeta{}, fmt.Errorf("failed to get layer %s: %w", layer.Digest, err)
+	}
+	if blobDigest := ociDigest(content); blobDigest != layer.Digest {
+		return nil, FetchMeta{}, fmt.Errorf("layer digest %s does not match manifest digest %s", blobDigest, layer.Digest)
+	}
+	return content, FetchMeta{ETag: digest}, nil
+}
+
+// platformManifest gets the manifest an index refers to for the platform
+// the server runs on. An index of a single manifest, or with a single one
+// for no particular platform, refers to it whatever the platform.
+func (o *OCISource) platformManifest(ctx context.Context, index *ociManifest) (*ociManifest, error) {
+	var descriptor *ociDescriptor
+	var generic []*ociDescriptor
+	for i, d := range index.Manifests {
+		switch {
+		case d.Platform == nil:
+			generic = append(generic, &index.Manifests[i])
+		case d.Platform.OS == runtime.GOOS && d.Platform.Architecture == runtime.GOARCH && descriptor == nil:
+			descriptor = &index.Manifests[i]
+		}
+	}
+	switch {
+	case descriptor != nil:
+	case len(index.Manifests) == 1:
+		descriptor = &index.Manifests[0]
+	case len(generic) == 1:
+		descriptor = generic[0]
+	default:
+		return nil, fmt.Errorf("index has %d manifests, none of them for %s/%s", len(index.Manifests), runtime.GOOS, runtime.GOARCH)
+	}
+
+	data, err := o.get(ctx, "manifests/"+descriptor.Digest, ociManifestAccept)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest %s: %w", descriptor.Digest, err)
+	}
+	if digest := ociDigest(data); digest != descriptor.Digest {
+		return nil, fmt.Errorf("manifest digest %s does not match index digest %s", digest, descriptor.Digest)
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", descriptor.Digest, err)
+	}
+	if manifest.isIndex() {
+		return nil, fmt.Errorf("manifest %s is an index nested in an index", descriptor.Digest)
+	}
+	return manifest, nil
+}
+
+func (o *OCISource) layer(manifest *ociManifest) (*ociDescriptor, error) {
+	if o.File == "" {
+		if len(manifest.Layers) != 1 {
+			return nil, fmt.Errorf("artifact has %d layers, set the file to select one", len(manifest.Layers))
+		}
+		return &manifest.Layers[0], nil
+	}
+	for i, layer := range manifest.Layers {
+		if layer.Annotations[ociTitleAnnotation] == o.File {
+			return &manifest.Layers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("artifact has no layer titled %s", o.File)
+}
+
+// get requests a path below the repository, authenticating and retrying
+// if the registry asks for it.
+func (o *OCISource) get(ctx context.Context, path, accept string) ([]byte, error) {
+	scheme := "https"
+	if o.Insecure {
+		scheme = "http"
+	}
+	address := fmt.Sprintf("%s://%s/v2/%s/%s", scheme, o.Registry, o.Repository, path)
+
+	resp, err := o.do(ctx, address, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := o.authenticate(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = o.do(ctx, address, accept); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %v", resp.Status)
+	}
+	return readBody(resp, o.MaxBodySize)
+}
+
+func (o *OCISource) do(ctx context.Context, address, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	o.lock.Lock()
+	token := o.token
+	o.lock.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	client := o.Client
+	if client == nil {
+		client = httpClient
+	}
+	return client.Do(req)
+}
+
+// authenticate handles the Basic and Bearer challenges of the registry
+// token authentication protocol, storing the Authorization header to use.
+func (o *OCISource) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	username, password := o.credentials()
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" {
+			return fmt.Errorf("registry %s requires credentials", o.Registry)
+		}
+		o.setToken("Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q from registry %s", challenge, o.Registry)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid token realm %q from registry %s", params["realm"], o.Registry)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + o.Repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	client := o.Client
+	if client == nil {
+		client = httpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get token from %s: status %v", realm.Host, resp.Status)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to parse token from %s: %w", realm.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	o.setToken("Bearer " + token.Token)
+	return nil
+}
+
+func (o *OCISource) setToken(token string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.token = token
+}
+
+// credentials returns the configured credentials, falling back to the ones
+// stored for the registry in the docker config file.
+func (o *OCISource) credentials() (string, string) {
+	if o.Username != "" {
+		return o.Username, o.Password
+	}
+
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+
+	config := struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", ""
+	}
+	for _, key := range []string{o.Registry, "https://" + o.Registry, "http://" + o.Registry} {
+		auth, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return "", ""
+			}
+			username, password, _ := strings.Cut(string(decoded), ":")
+			return username, password
+		}
+		return auth.Username, auth.Password
+	}
+	return "", ""
+}
+
+func ociDigest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry".
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
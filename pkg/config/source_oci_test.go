@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testRegistry is an OCI distribution registry serving manifests and blobs
+// from memory to clients holding a token from its token endpoint.
+type testRegistry struct {
+	*httptest.Server
+	manifests map[string]testManifest
+	blobs     map[string][]byte
+}
+
+type testManifest struct {
+	mediaType string
+	data      []byte
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+	// Keep credentials of the user running the tests out of the requests.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	r := &testRegistry{manifests: map[string]testManifest{}, blobs: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != "user" || password != "s3cr3t" || req.URL.Query().Get("scope") != "repository:config/channels:pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+	})
+	mux.HandleFunc("/v2/config/channels/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="registry",scope="repository:config/channels:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		kind, reference, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/config/channels/"), "/")
+		switch kind {
+		case "manifests":
+			manifest, ok := r.manifests[reference]
+			if !ok || !strings.Contains(req.Header.Get("Accept"), manifest.mediaType) {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("Content-Type", manifest.mediaType)
+			w.Write(manifest.data)
+		case "blobs":
+			blob, ok := r.blobs[reference]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write(blob)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+	return r
+}
+
+// blob stores content, returning its descriptor.
+func (r *testRegistry) blob(title string, content []byte) ociDescriptor {
+	digest := ociDigest(content)
+	r.blobs[digest] = content
+	return ociDescriptor{
+		MediaType:   "application/yaml",
+		Digest:      digest,
+		Size:        int64(len(content)),
+		Annotations: map[string]string{ociTitleAnnotation: title},
+	}
+}
+
+// manifest stores manifest under its digest and the given tags, returning
+// its descriptor.
+func (r *testRegistry) manifest(t *testing.T, manifest ociManifest, tags ...string) ociDescriptor {
+	t.Helper()
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := ociDigest(data)
+	for _, reference := range append(tags, digest) {
+		r.manifests[reference] = testManifest{mediaType: manifest.MediaType, data: data}
+	}
+	return ociDescriptor{MediaType: manifest.MediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// digest returns the digest of the manifest tagged tag.
+func (r *testRegistry) digest(tag string) string {
+	return ociDigest(r.manifests[tag].data)
+}
+
+func TestOCISourceFetch(t *testing.T) {
+	r := newTestRegistry(t)
+	channels := r.blob("channels.yaml", []byte(testConfig))
+	signature := r.blob("channels.yaml.sig", []byte("signature"))
+	other := r.blob("channels.yaml", []byte("other platform"))
+
+	single := r.manifest(t, ociManifest{MediaType: ociManifestMediaType, Layers: []ociDescriptor{channels, signature}}, "v1")
+	r.manifest(t, ociManifest{MediaType: dockerManifestMediaType, Layers: []ociDescriptor{channels}}, "docker")
+	otherPlatform := r.manifest(t, ociManifest{MediaType: ociManifestMediaType, Layers: []ociDescriptor{other}})
+
+	platform := single
+	platform.Platform = &ociPlatform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	otherPlatform.Platform = &ociPlatform{OS: "plan9", Architecture: "mips"}
+	index := r.manifest(t, ociManifest{MediaType: ociIndexMediaType, Manifests: []ociDescriptor{otherPlatform, platform}}, "index")
+	r.manifest(t, ociManifest{MediaType: dockerManifestListMediaType, Manifests: []ociDescriptor{single}}, "list")
+	armPlatform := otherPlatform
+	armPlatform.Platform = &ociPlatform{OS: "plan9", Architecture: "arm"}
+	r.manifest(t, ociManifest{MediaType: ociIndexMediaType, Manifests: []ociDescriptor{otherPlatform, armPlatform}}, "unsupported")
+	// A registry serving other content than the digest it is asked for.
+	tampered := "sha256:" + strings.Repeat("0", 64)
+	r.manifests[tampered] = r.manifests["v1"]
+
+	host := strings.TrimPrefix(r.URL, "http://")
+	tests := []struct {
+		name         string
+		url          string
+		wantManifest string
+		wantErr      string
+	}{
+		{name: "manifest", url: "oci://user:s3cr3t@" + host + "/config/channels:v1?file=channels.yaml", wantManifest: single.Digest},
+		{name: "docker manifest", url: "oci://user:s3cr3t@" + host + "/config/channels:docker", wantManifest: r.digest("docker")},
+		{name: "pinned digest", url: "oci://user:s3cr3t@" + host + "/config/channels@" + single.Digest + "?file=channels.yaml", wantManifest: single.Digest},
+		{name: "index", url: "oci://user:s3cr3t@" + host + "/config/channels:index?file=channels.yaml", wantManifest: index.Digest},
+		{name: "manifest list", url: "oci://user:s3cr3t@" + host + "/config/channels:list?file=channels.yaml", wantManifest: r.digest("list")},
+		{name: "index without the platform", url: "oci://user:s3cr3t@" + host + "/config/channels:unsupported", wantErr: "none of them for"},
+		{name: "mismatched pinned digest", url: "oci://user:s3cr3t@" + host + "/config/channels@" + tampered + "?file=channels.yaml", wantErr: "does not match"},
+		{name: "several layers", url: "oci://user:s3cr3t@" + host + "/config/channels:v1", wantErr: "set the file"},
+		{name: "missing layer", url: "oci://user:s3cr3t@" + host + "/config/channels:v1?file=missing.yaml", wantErr: "no layer titled"},
+		{name: "missing tag", url: "oci://user:s3cr3t@" + host + "/config/channels:v2", wantErr: "404"},
+		{name: "wrong password", url: "oci://user:wrong@" + host + "/config/channels:v1?file=channels.yaml", wantErr: "failed to get token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := NewSource(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, meta, err := source.(ConditionalSource).FetchConditional(context.Background(), FetchMeta{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != testConfig {
+				t.Errorf("fetched %q", content)
+			}
+			if meta.ETag != tt.wantManifest {
+				t.Errorf("ETag %s, want %s", meta.ETag, tt.wantManifest)
+			}
+		})
+	}
+}
+
+func TestOCISourceSibling(t *testing.T) {
+	r := newTestRegistry(t)
+	channels := r.blob("channels.yaml", []byte(testConfig))
+	signature := r.blob("channels.yaml.sig", []byte("signature"))
+	first := r.manifest(t, ociManifest{MediaType: ociManifestMediaType, Layers: []ociDescriptor{channels, signature}})
+	first.Platform = &ociPlatform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	r.manifest(t, ociManifest{MediaType: ociIndexMediaType, Manifests: []ociDescriptor{first}}, "latest")
+
+	source, err := NewSource("oci://user:s3cr3t@" + strings.TrimPrefix(r.URL, "http://") + "/config/channels?file=channels.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature is read from the artifact already fetched, even once
+	// the tag is moved.
+	r.manifest(t, ociManifest{MediaType: ociManifestMediaType, Layers: []ociDescriptor{r.blob("channels.yaml", []byte("next"))}}, "latest")
+	sibling, err := source.(SiblingSource).Sibling(".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := sibling.Fetch(context.Background()); err != nil || string(content) != "signature" {
+		t.Errorf("fetched signature %q: %v", content, err)
+	}
+}
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Source reads channel configuration from an object in S3 compatible
+// storage, signing requests with AWS Signature Version 4.
+type S3Source struct {
+	Bucket string
+	Key    string
+	// Endpoint of the storage service, such as http://minio:9000. Defaults
+	// to the AWS endpoint of Region.
+	Endpoint string
+	Region   string
+	// PathStyle puts the bucket in the request path rather than the host
+	// name, as most S3 compatible services other than AWS require.
+	PathStyle bool
+	// AccessKeyID, SecretAccessKey and SessionToken are static credentials.
+	// If unset the standard AWS environment variables are used, and without
+	// those requests are made anonymously.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Client          *http.Client
+	// MaxBodySize limits the size of fetched content, if set.
+	MaxBodySize int64
+}
+
+// newS3Source handles URLs of the form s3://bucket/key with optional
+// endpoint, region and pathStyle query parameters. Static credentials can be
+// given as the user info of the URL.
+func newS3Source(u *url.URL) (Source, error) {
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, fmt.Errorf("S3 source %s must be of the form s3://bucket/key", u.Redacted())
+	}
+
+	query := u.Query()
+	source := &S3Source{
+		Bucket:   u.Host,
+		Key:      strings.TrimPrefix(u.Path, "/"),
+		Endpoint: query.Get("endpoint"),
+		Region:   query.Get("region"),
+	}
+	if source.Endpoint == "" {
+		source.Endpoint = firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	}
+	if source.Region == "" {
+		source.Region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+	if source.Region == "" {
+		source.Region = "us-east-1"
+	}
+	if pathStyle := query.Get("pathStyle"); pathStyle != "" {
+		source.PathStyle = pathStyle == "true"
+	} else {
+		source.PathStyle = source.Endpoint != ""
+	}
+	if u.User != nil {
+		source.AccessKeyID = u.User.Username()
+		source.SecretAccessKey, _ = u.User.Password()
+	}
+	return source, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (s *S3Source) URL() string {
+	u := url.URL{Scheme: "s3", Host: s.Bucket, Path: "/" + s.Key}
+	query := url.Values{}
+	if s.Endpoint != "" {
+		query.Set("endpoint", s.Endpoint)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (s *S3Source) Sibling(suffix string) (Source, error) {
+	sibling := *s
+	sibling.Key += suffix
+	return &sibling, nil
+}
+
+func (s *S3Source) Resolve(ref string) (Source, error) {
+	resolved := *s
+	resolved.Key = joinPath(s.Key, ref)
+	return &resolved, nil
+}
+
+func (s *S3Source) Fetch(ctx context.Context) ([]byte, error) {
+	content, _, err := s.FetchConditional(ctx, FetchMeta{})
+	return content, err
+}
+
+// FetchConditional sends the ETag in prev as If-None-Match, returning
+// ErrNotModified if the object has not changed.
+func (s *S3Source) FetchConditional(ctx context.Context, prev FetchMeta) ([]byte, FetchMeta, error) {
+	address, err := s.objectURL()
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address.String(), nil)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if err := s.sign(req, time.Now()); err != nil {
+		return nil, FetchMeta{}, err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = httpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, prev, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, FetchMeta{}, fmt.Errorf("status %v: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	content, err := readBody(resp, s.MaxBodySize)
+	if err != nil {
+		return nil, FetchMeta{}, err
+	}
+	return content, FetchMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+func (s *S3Source) objectURL() (*url.URL, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: %w", endpoint, err)
+	}
+	if s.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket + "/" + s.Key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	return u, nil
+}
+
+func (s *S3Source) credentials() (string, string, string) {
+	if s.AccessKeyID != "" {
+		return s.AccessKeyID, s.SecretAccessKey, s.SessionToken
+	}
+	return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, covering
+// the host and all headers already set on it. Without credentials the
+// request is left unsigned.
+func (s *S3Source) sign(req *http.Request, now time.Time) error {
+	accessKeyID, secretAccessKey, sessionToken := s.credentials()
+	if accessKeyID == "" {
+		return nil
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent encodes everything but unreserved characters, as
+// required by Signature Version 4. Slashes are kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
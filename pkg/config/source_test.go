@@ -0,0 +1,186 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewSource(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    interface{}
+		wantErr bool
+	}{
+		{url: "channels.yaml", want: &FileSource{}},
+		{url: "/etc/channels.yaml", want: &FileSource{}},
+		{url: `C:\config\channels.yaml`, want: &FileSource{}},
+		{url: "file:///etc/channels.yaml", want: &FileSource{}},
+		{url: "https://example.com/channels.yaml", want: &HTTPSource{}},
+		{url: "HTTP://example.com/channels.yaml", want: &HTTPSource{}},
+		{url: "github://rancher/config/channels.yaml@main", want: &GitHubSource{}},
+		{url: "s3://bucket/channels.yaml", want: &S3Source{}},
+		{url: "oci://registry.example.com/config/channels:v1", want: &OCISource{}},
+		{url: "git+https://github.com/org/config.git?path=channels.yaml", want: &GitSource{}},
+		{url: "ftp://example.com/channels.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			source, err := NewSource(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %T", source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprintf("%T", source), fmt.Sprintf("%T", tt.want); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestFileSourceURL(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "channels.yaml")
+	want := "file://" + filepath.ToSlash(path)
+	if filepath.VolumeName(path) != "" {
+		want = "file:///" + filepath.ToSlash(path)
+	}
+
+	for _, rawURL := range []string{path, "file://" + filepath.ToSlash(path)} {
+		source, err := NewSource(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := source.URL(); got != want {
+			t.Errorf("URL() of %s = %s, want %s", rawURL, got, want)
+		}
+	}
+
+	relative := &FileSource{Path: "channels.yaml"}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := relative.URL(), (&FileSource{Path: filepath.Join(wd, "channels.yaml")}).URL(); got != want {
+		t.Errorf("URL() of a relative path = %s, want %s", got, want)
+	}
+}
+
+func TestStringSourceFetchConditional(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("channels: []\n"))
+	}))
+	defer server.Close()
+
+	source := StringSource(server.URL + "/channels.yaml")
+	if _, ok := Source(source).(ConditionalSource); !ok {
+		t.Fatal("StringSource is not a ConditionalSource")
+	}
+	content, meta, err := source.FetchConditional(context.Background(), FetchMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "channels: []\n" || meta.ETag != `"v1"` {
+		t.Fatalf("got %q with ETag %s", content, meta.ETag)
+	}
+	if _, _, err := source.FetchConditional(context.Background(), meta); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("expected ErrNotModified, got %v", err)
+	}
+}
+
+func TestStringSourceWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "channels.yaml")
+	if err := os.WriteFile(path, []byte("channels: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	err := StringSource(path).Watch(ctx, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("channels: [{name: stable}]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change to the file was not reported")
+	}
+
+	// Sources that cannot be watched are reloaded on the refresh schedule.
+	if err := StringSource("https://example.com/channels.yaml").Watch(ctx, func() {}); err != nil {
+		t.Fatalf("watching an HTTP source: %v", err)
+	}
+}
+
+func TestStringSourceIncludesAndSignatures(t *testing.T) {
+	signer := newTestSigners(t)["ed25519"]
+	dir := t.TempDir()
+	verifier, err := LoadVerifier([]string{writePublicKey(t, dir, "key", signer.public)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const config = "k3s:\n  channels:\n    $include: k3s/channels.yaml\n"
+	const included = "- name: stable\n  latest: v1.30.4+k3s1\n"
+	path := writeFile(t, filepath.Join(dir, "channels.yaml"), config)
+	writeFile(t, path+".sig", string(signer.sign([]byte(config))))
+	writeFile(t, filepath.Join(dir, "k3s", "channels.yaml"), included)
+	writeFile(t, filepath.Join(dir, "k3s", "channels.yaml.sig"), string(signer.sign([]byte(included))))
+
+	for _, url := range []string{path, "file://" + filepath.ToSlash(path)} {
+		t.Run(url, func(t *testing.T) {
+			c := newConfig("k3s", []Source{StringSource(url)}, Options{Verifier: verifier})
+			if _, err := c.loadConfig(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := channelNames(c); len(got) != 1 || got[0] != "stable=v1.30.4+k3s1" {
+				t.Errorf("served channels %v, want the included stable channel", got)
+			}
+		})
+	}
+}
+
+func TestStringSourceClosesLocatedSources(t *testing.T) {
+	remote, _, _ := gitRemote(t)
+	t.Setenv("TMPDIR", t.TempDir())
+
+	source := StringSource("git+file://" + filepath.ToSlash(remote) + "?ref=v1&path=channels.yaml")
+	signature, err := source.Sibling(".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := signature.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v1 signature" {
+		t.Errorf("fetched signature %q, want the one of v1", content)
+	}
+	if dirs := gitDirs(t); len(dirs) != 0 {
+		t.Errorf("repositories %v left after fetching", dirs)
+	}
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// reference matches ${NAME}, ${NAME:-default} and ${file:/path} references,
+// and $${ as an escaped ${.
+var reference = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// DefaultVariablePrefix is the prefix of the environment variables a config
+// can refer to if no other is configured.
+const DefaultVariablePrefix = "CHANNELSERVER_"
+
+// Variables configure the references to the environment and to files that
+// are substituted in the string values of a config.
+type Variables struct {
+	// Prefix is the prefix of the names of the environment variables that
+	// can be referred to, DefaultVariablePrefix if empty.
+	Prefix string
+	// FileDir is the directory holding the files that can be referred to.
+	// Files cannot be referred to if it is empty.
+	FileDir string
+	// Remote substitutes references in configs assembled from sources,
+	// includes or overlays other than local files. Otherwise such configs
+	// fail to load if they have any.
+	Remote bool
+}
+
+// variables resolves the references of a config assembled from files,
+// recording the values they resolved to.
+type variables struct {
+	Variables
+	// disabled is set if references are not substituted, as the config
+	// was assembled from files that are not local.
+	disabled bool
+	resolved map[string]string
+}
+
+// resolver returns the variables of a config assembled from the files at
+// urls.
+func (v Variables) resolver(urls ...string) *variables {
+	vars := &variables{Variables: v, resolved: map[string]string{}}
+	if v.Prefix == "" {
+		vars.Prefix = DefaultVariablePrefix
+	}
+	for _, url := range urls {
+		if !v.Remote && urlOrigin(url) != "file://" {
+			vars.disabled = true
+		}
+	}
+	return vars
+}
+
+// fingerprintKey keys the fingerprint of the values references resolved
+// to, so that it does not reveal them.
+var fingerprintKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// fingerprint identifies the values the references resolved to, so that
+// the config is loaded again when one of them changes even if the config
+// did not. It is empty if there are no references.
+func (v *variables) fingerprint() string {
+	if len(v.resolved) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, fingerprintKey)
+	for _, name := range v.references() {
+		fmt.Fprintf(mac, "%s\x00%s\x00", name, v.resolved[name])
+	}
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+// references returns the sorted references that were resolved.
+func (v *variables) references() []string {
+	names := make([]string, 0, len(v.resolved))
+	for name := range v.resolved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// substitute replaces references in the string values of the config for
+// subKey and the subkeys it extends, or of the whole config if subKey is
+// empty, changing doc in place. ${NAME} is replaced by the environment
+// variable NAME, or by default for ${NAME:-default} if it is not set,
+// ${file:path} by the content of the file without trailing newlines, and $${
+// by ${. A reference to an undefined variable, a variable without the
+// configured prefix or a file outside of the configured directory is an
+// error, as is any reference if substitution is disabled.
+func (v *variables) substitute(doc interface{}, subKey string) (interface{}, error) {
+	var errs []error
+	if subKey == "" {
+		doc = v.substituteValue(doc, "", &errs)
+	} else if data, ok := doc.(map[string]interface{}); ok {
+		for _, key := range extendedKeys(data, subKey) {
+			data[key] = v.substituteValue(data[key], key, &errs)
+		}
+	}
+	return doc, errors.Join(errs...)
+}
+
+func (v *variables) substituteValue(node interface{}, path string, errs *[]error) interface{} {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		for key, value := range node {
+			node[key] = v.substituteValue(value, joinField(path, key), errs)
+		}
+	case []interface{}:
+		for i, value := range node {
+			node[i] = v.substituteValue(value, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case string:
+		if !strings.Contains(node, "${") {
+			return node
+		}
+		return reference.ReplaceAllStringFunc(node, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+			if v.disabled {
+				*errs = append(*errs, fmt.Errorf("%s: %s is not substituted in config from sources other than local files unless --remote-variables is set", path, match))
+				return match
+			}
+			value, err := v.lookup(match[2 : len(match)-1])
+			if err != nil {
+				*errs = append(*errs, fmt.Errorf("%s: %w", path, err))
+			}
+			return value
+		})
+	}
+	return node
+}
+
+// lookup resolves the reference name, the text between ${ and }.
+func (v *variables) lookup(name string) (string, error) {
+	value, err := v.resolve(name)
+	if err == nil {
+		v.resolved[name] = value
+	}
+	return value, err
+}
+
+func (v *variables) resolve(name string) (string, error) {
+	if file, ok := strings.CutPrefix(name, "file:"); ok {
+		data, err := v.readFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, fallback, hasFallback := strings.Cut(name, ":-")
+	if !strings.HasPrefix(name, v.Prefix) {
+		return "", fmt.Errorf("variable %s cannot be referred to, only variables starting with %s can", name, v.Prefix)
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+	if hasFallback {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("undefined variable %s", name)
+}
+
+// readFile reads the file at path, relative to the configured directory
+// unless absolute. Files outside of the directory cannot be read, even
+// through symbolic links.
+func (v *variables) readFile(path string) ([]byte, error) {
+	if v.FileDir == "" {
+		return nil, fmt.Errorf("file %s cannot be referred to, no directory of files is configured", path)
+	}
+	dir, err := filepath.Abs(v.FileDir)
+	if err != nil {
+		return nil, err
+	}
+	rel := path
+	if filepath.IsAbs(path) {
+		if rel, err = filepath.Rel(dir, filepath.Clean(path)); err != nil {
+			return nil, err
+		}
+	}
+	if !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("file %s cannot be referred to, it is not in %s", path, v.FileDir)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.ReadFile(rel)
+}
+
+// joinField appends a field name to the dotted path of a value.
+func joinField(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
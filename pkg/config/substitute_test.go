@@ -0,0 +1,123 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVariablesSubstitute(t *testing.T) {
+	t.Setenv("CHANNELSERVER_MIRROR", "https://mirror.example.com")
+	t.Setenv("HOME_MIRROR", "https://home.example.com")
+	dir := t.TempDir()
+	secrets := filepath.Join(dir, "secrets")
+	writeFile(t, filepath.Join(secrets, "token"), "s3cr3t\n")
+	outside := writeFile(t, filepath.Join(dir, "outside"), "outside\n")
+	symlink(t, outside, filepath.Join(secrets, "link"))
+
+	tests := []struct {
+		name    string
+		value   string
+		vars    Variables
+		urls    []string
+		want    string
+		wantErr string
+	}{
+		{name: "variable", value: "${CHANNELSERVER_MIRROR}/k3s", want: "https://mirror.example.com/k3s"},
+		{name: "default of a set variable", value: "${CHANNELSERVER_MIRROR:-https://github.com}", want: "https://mirror.example.com"},
+		{name: "default", value: "${CHANNELSERVER_UNSET:-https://github.com}", want: "https://github.com"},
+		{name: "undefined", value: "${CHANNELSERVER_UNSET}", wantErr: "undefined variable CHANNELSERVER_UNSET"},
+		{name: "without the prefix", value: "${HOME_MIRROR}", wantErr: "only variables starting with CHANNELSERVER_"},
+		{name: "default without the prefix", value: "${HOME_MIRROR:-https://github.com}", wantErr: "cannot be referred to"},
+		{name: "other prefix", value: "${HOME_MIRROR}", vars: Variables{Prefix: "HOME_"}, want: "https://home.example.com"},
+		{name: "escaped", value: "$${CHANNELSERVER_MIRROR}", want: "${CHANNELSERVER_MIRROR}"},
+		{name: "file", value: "${file:" + filepath.Join(secrets, "token") + "}", vars: Variables{FileDir: secrets}, want: "s3cr3t"},
+		{name: "relative file", value: "${file:token}", vars: Variables{FileDir: secrets}, want: "s3cr3t"},
+		{name: "file without a directory", value: "${file:" + filepath.Join(secrets, "token") + "}", wantErr: "no directory of files"},
+		{name: "file outside of the directory", value: "${file:" + outside + "}", vars: Variables{FileDir: secrets}, wantErr: "is not in"},
+		{name: "relative file outside of the directory", value: "${file:../outside}", vars: Variables{FileDir: secrets}, wantErr: "is not in"},
+		{name: "link out of the directory", value: "${file:link}", vars: Variables{FileDir: secrets}, wantErr: "escapes"},
+		{name: "local files", value: "${CHANNELSERVER_MIRROR}", urls: []string{"file:///etc/channels.yaml", "file:///etc/k3s.yaml"}, want: "https://mirror.example.com"},
+		{name: "remote file", value: "${CHANNELSERVER_MIRROR}", urls: []string{"file:///etc/channels.yaml", "https://example.com/k3s.yaml"}, wantErr: "unless --remote-variables is set"},
+		{name: "remote file escaped", value: "$${CHANNELSERVER_MIRROR}", urls: []string{"https://example.com/k3s.yaml"}, want: "${CHANNELSERVER_MIRROR}"},
+		{name: "remote file allowed", value: "${CHANNELSERVER_MIRROR}", vars: Variables{Remote: true}, urls: []string{"https://example.com/k3s.yaml"}, want: "https://mirror.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := map[string]interface{}{"k3s": map[string]interface{}{"redirectBase": tt.value}}
+			result, err := tt.vars.resolver(tt.urls...).substitute(doc, "k3s")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := json.Marshal(result)
+			if got := result.(map[string]interface{})["k3s"].(map[string]interface{})["redirectBase"]; got != tt.want {
+				t.Errorf("got %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigVariables(t *testing.T) {
+	const config = "k3s:\n  channels:\n  - name: stable\n    latest: ${CHANNELSERVER_STABLE}\n"
+	local := writeFile(t, filepath.Join(t.TempDir(), "channels.yaml"), config)
+
+	tests := []struct {
+		name        string
+		source      Source
+		vars        Variables
+		stable      string
+		wantChanged bool
+		want        string
+		wantErr     string
+	}{
+		{name: "initial load", source: &FileSource{Path: local}, stable: "v1.30.4+k3s1", wantChanged: true, want: "stable=v1.30.4+k3s1"},
+		{name: "unchanged", source: &FileSource{Path: local}, stable: "v1.30.4+k3s1", want: "stable=v1.30.4+k3s1"},
+		// The config is applied again, though it did not change.
+		{name: "changed variable", source: &FileSource{Path: local}, stable: "v1.31.0+k3s1", wantChanged: true, want: "stable=v1.31.0+k3s1"},
+		{name: "remote source", source: newTestSource("https://example.com/channels.yaml", config), stable: "v1.31.0+k3s1", wantErr: "k3s.channels[0].latest: ${CHANNELSERVER_STABLE} is not substituted in config from sources other than local files unless --remote-variables is set"},
+		{name: "remote source escaped", source: newTestSource("https://example.com/escaped.yaml", strings.ReplaceAll(config, "${", "$${")), stable: "v1.31.0+k3s1", wantChanged: true, want: "stable=${CHANNELSERVER_STABLE}"},
+		{name: "remote source allowed", source: newTestSource("https://example.com/channels.yaml", config), vars: Variables{Remote: true}, stable: "v1.31.0+k3s1", wantChanged: true, want: "stable=v1.31.0+k3s1"},
+	}
+	var c *Config
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHANNELSERVER_STABLE", tt.stable)
+			if c == nil || c.urls[0].URL() != tt.source.URL() || c.variables != tt.vars {
+				c = newConfig("k3s", []Source{tt.source}, Options{Variables: tt.vars})
+			}
+			changed, err := c.loadConfig(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if got := channelNames(c); !slices.Equal(got, []string{tt.want}) {
+				t.Errorf("served channels %v, want %s", got, tt.want)
+			}
+			// The digest is that of the content, whatever the values of
+			// its references.
+			content, _ := tt.source.Fetch(context.Background())
+			if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content)); c.Status().Digest != digest {
+				t.Errorf("digest %s, want %s", c.Status().Digest, digest)
+			}
+		})
+	}
+}
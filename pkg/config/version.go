@@ -0,0 +1,213 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rancher/channelserver/pkg/model"
+	"sigs.k8s.io/yaml"
+)
+
+// versionKey is the top-level key holding the version of the config format.
+const versionKey = "apiVersion"
+
+// formatVersion is a version of the config format.
+type formatVersion struct {
+	name string
+	// migrate converts a config of the version before to this one in
+	// place. It is not set if there is nothing to convert.
+	migrate func(config map[string]interface{}) error
+}
+
+// versions are the versions of the config format, oldest first. Configs
+// without a version are of the first. The last is model.APIVersion.
+var versions = []formatVersion{
+	{name: "v1"},
+}
+
+var (
+	versionLine     = regexp.MustCompile(`(?m)^` + versionKey + `:.*$`)
+	tomlVersionLine = regexp.MustCompile(`(?m)^` + versionKey + `\s*=.*$`)
+	jsonVersion     = regexp.MustCompile(`"` + versionKey + `"\s*:\s*"[^"]*"`)
+)
+
+// configVersion returns the version of the config format doc, the generic
+// form of a config, is written in.
+func configVersion(doc interface{}) (string, error) {
+	data, _ := doc.(map[string]interface{})
+	value, ok := data[versionKey]
+	if !ok {
+		return versions[0].name, nil
+	}
+	version, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, found %s", versionKey, typeName(value))
+	}
+	return version, nil
+}
+
+// versionIndex returns the position of version in versions.
+func versionIndex(version string) (int, error) {
+	for i, v := range versions {
+		if v.name == version {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unsupported %s %q, this channel server reads up to %s", versionKey, version, model.APIVersion)
+}
+
+// migrateVersion converts doc, changed in place, from version to the newest
+// version of the format. If byKey is set every top-level mapping of doc is
+// a config, as a config may extend any other, and otherwise doc is a single
+// config.
+func migrateVersion(doc interface{}, version string, byKey bool) (interface{}, error) {
+	from, err := versionIndex(version)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := doc.(map[string]interface{})
+	if !ok || from == len(versions)-1 {
+		return doc, nil
+	}
+
+	configs := []map[string]interface{}{data}
+	if byKey {
+		configs = nil
+		for _, value := range data {
+			if config, ok := value.(map[string]interface{}); ok {
+				configs = append(configs, config)
+			}
+		}
+	}
+	for _, next := range versions[from+1:] {
+		if next.migrate == nil {
+			continue
+		}
+		for _, config := range configs {
+			if err := next.migrate(config); err != nil {
+				return nil, fmt.Errorf("failed to migrate config to %s: %w", next.name, err)
+			}
+		}
+	}
+	data[versionKey] = model.APIVersion
+	return data, nil
+}
+
+// Migrate rewrites a config file in format in the newest version of the
+// format. Files already in it are returned unchanged. If only the version
+// changes, the apiVersion is replaced or added in place so that comments,
+// formatting and the order of keys are kept; otherwise the config is written
+// again in the same syntax, YAML, JSON or TOML. The documents of YAML with
+// several are written as one.
+func Migrate(content []byte, format Format) ([]byte, error) {
+	doc, err := parseConfig(content, format)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("config must be a mapping, found %s", typeName(doc))
+	}
+	version, err := configVersion(data)
+	if err != nil {
+		return nil, err
+	}
+	if version == model.APIVersion {
+		return content, nil
+	}
+	migrated, err := migrateVersion(deepCopy(data), version, !isSingleConfig(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if reflect.DeepEqual(withoutKey(data, versionKey), withoutKey(migrated.(map[string]interface{}), versionKey)) {
+		// The version is set in place only if the result still reads as
+		// the migrated config, as it would not after a YAML flow mapping.
+		if versioned, ok := setVersion(content, format, data[versionKey] != nil); ok {
+			if doc, err := parseConfig(versioned, format); err == nil && reflect.DeepEqual(doc, migrated) {
+				return versioned, nil
+			}
+		}
+	}
+	switch format {
+	case FormatJSON:
+		return marshalIndent(migrated)
+	case FormatTOML:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(migrated); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return yaml.Marshal(migrated)
+}
+
+// setVersion replaces the apiVersion of content in format with the newest
+// version, or adds it if versioned is not set. It reports false if the
+// version cannot be set in place.
+func setVersion(content []byte, format Format, versioned bool) ([]byte, bool) {
+	switch format {
+	case FormatYAML:
+		line := versionKey + ": " + model.APIVersion
+		if versioned {
+			return versionLine.ReplaceAll(content, []byte(line)), true
+		}
+		if rest, ok := strings.CutPrefix(string(content), "---\n"); ok {
+			return []byte("---\n" + line + "\n" + rest), true
+		}
+		return append([]byte(line+"\n"), content...), true
+	case FormatTOML:
+		// Keys before the first table are those of the root table.
+		line := fmt.Sprintf("%s = %q", versionKey, model.APIVersion)
+		if versioned {
+			return tomlVersionLine.ReplaceAll(content, []byte(line)), true
+		}
+		return append([]byte(line+"\n"), content...), true
+	case FormatJSON:
+		field := fmt.Sprintf("%q: %q", versionKey, model.APIVersion)
+		if versioned {
+			return jsonVersion.ReplaceAll(content, []byte(field)), true
+		}
+		// The field is added before the first of the object, keeping the
+		// indentation of the line it is on.
+		start := bytes.IndexByte(content, '{') + 1
+		if start == 0 {
+			return nil, false
+		}
+		rest := content[start:]
+		space := rest[:len(rest)-len(bytes.TrimLeft(rest, " \t\r\n"))]
+		if len(space) == len(rest) || rest[len(space)] == '}' {
+			return nil, false
+		}
+		return []byte(string(content[:start]) + string(space) + field + "," + string(rest)), true
+	}
+	return nil, false
+}
+
+// isSingleConfig reports whether data is the config of a single product
+// rather than configs by key, as it has the fields of one at the top level.
+func isSingleConfig(data map[string]interface{}) bool {
+	root, err := loadSchema()
+	if err != nil {
+		return false
+	}
+	for key := range data {
+		if _, ok := root.Defs["config"].Properties[key]; ok && key != versionKey {
+			return true
+		}
+	}
+	return false
+}
+
+func marshalIndent(doc interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
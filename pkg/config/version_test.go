@@ -0,0 +1,150 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// withEarlierVersion adds a v0 of the config format before v1 for the
+// duration of the test, in which the regexps of channels were named
+// latestRegex and excludeRegex.
+func withEarlierVersion(t *testing.T) {
+	saved := versions
+	t.Cleanup(func() { versions = saved })
+	versions = []formatVersion{
+		{name: "v0"},
+		{
+			name: "v1",
+			migrate: func(config map[string]interface{}) error {
+				channels, _ := config["channels"].([]interface{})
+				for _, channel := range channels {
+					channel, _ := channel.(map[string]interface{})
+					for from, to := range map[string]string{"latestRegex": "latestRegexp", "excludeRegex": "excludeRegexp"} {
+						if value, ok := channel[from]; ok {
+							delete(channel, from)
+							channel[to] = value
+						}
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func TestParseDocumentVersion(t *testing.T) {
+	withEarlierVersion(t)
+
+	tests := []struct {
+		name    string
+		config  string
+		subKey  string
+		want    string
+		wantErr string
+	}{
+		{name: "current version", config: "apiVersion: v1\nk3s:\n  channels:\n  - name: latest\n    latestRegexp: .*\n", subKey: "k3s", want: ".*"},
+		{name: "earlier version", config: "apiVersion: v0\nk3s:\n  channels:\n  - name: latest\n    latestRegex: .*\n", subKey: "k3s", want: ".*"},
+		{name: "no version", config: "k3s:\n  channels:\n  - name: latest\n    latestRegex: .*\n", subKey: "k3s", want: ".*"},
+		{name: "extended subkey", config: "apiVersion: v0\nk3s:\n  channels:\n  - name: latest\n    latestRegex: .*\nrke2:\n  extends: k3s\n", subKey: "rke2", want: ".*"},
+		{name: "single config", config: "apiVersion: v0\nchannels:\n- name: latest\n  latestRegex: .*\n", want: ".*"},
+		{name: "not a mapping", config: "- apiVersion: v9\n", wantErr: "must be a mapping"},
+		{name: "unsupported version", config: "apiVersion: v2\nk3s:\n  channels: []\n", subKey: "k3s", wantErr: `unsupported apiVersion "v2", this channel server reads up to v1`},
+		{name: "not a string", config: "apiVersion: [v1]\nk3s:\n  channels: []\n", subKey: "k3s", wantErr: "apiVersion must be a string, found a list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := ParseDocument([]byte(tt.config), tt.subKey)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if channels := document.ChannelsConfig().Channels; len(channels) != 1 || channels[0].LatestRegexp != tt.want {
+				t.Errorf("got channels %+v, want one with latestRegexp %q", channels, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	withEarlierVersion(t)
+
+	tests := []struct {
+		name    string
+		format  Format
+		content string
+		// want is the migrated content, the content itself if empty.
+		want    string
+		wantErr string
+	}{
+		{name: "YAML in the newest version", format: FormatYAML, content: "# channels\napiVersion: v1\nk3s:\n  channels: []\n"},
+		{name: "JSON in the newest version", format: FormatJSON, content: "{\"k3s\": {\"channels\": []}, \"apiVersion\": \"v1\", \"b\": {}, \"a\": {}}\n"},
+		{name: "YAML without a version", format: FormatYAML, content: "# channels\nk3s:\n  channels: []\n", want: "apiVersion: v1\n# channels\nk3s:\n  channels: []\n"},
+		{name: "YAML version replaced", format: FormatYAML, content: "# channels\napiVersion: v0\nk3s:\n  channels: []\n", want: "# channels\napiVersion: v1\nk3s:\n  channels: []\n"},
+		{name: "YAML documents", format: FormatYAML, content: "---\nk3s:\n  channels: []\n---\nrke2:\n  channels: []\n", want: "---\napiVersion: v1\nk3s:\n  channels: []\n---\nrke2:\n  channels: []\n"},
+		{name: "YAML flow mapping", format: FormatYAML, content: "{k3s: {channels: []}}\n", want: "apiVersion: v1\nk3s:\n  channels: []\n"},
+		{name: "JSON without a version", format: FormatJSON, content: "{\n  \"rke2\": {},\n  \"k3s\": {}\n}\n", want: "{\n  \"apiVersion\": \"v1\",\n  \"rke2\": {},\n  \"k3s\": {}\n}\n"},
+		{name: "JSON version replaced", format: FormatJSON, content: "{\"rke2\": {}, \"apiVersion\": \"v0\", \"k3s\": {}}", want: "{\"rke2\": {}, \"apiVersion\": \"v1\", \"k3s\": {}}"},
+		{name: "empty JSON", format: FormatJSON, content: "{}", want: "{\n  \"apiVersion\": \"v1\"\n}\n"},
+		{name: "TOML without a version", format: FormatTOML, content: "[[k3s.channels]]\nname = \"stable\"\n", want: "apiVersion = \"v1\"\n[[k3s.channels]]\nname = \"stable\"\n"},
+		{
+			name:    "YAML migrated",
+			format:  FormatYAML,
+			content: "# channels\nk3s:\n  channels:\n  - name: latest\n    latestRegex: .*\n",
+			want:    "apiVersion: v1\nk3s:\n  channels:\n  - latestRegexp: .*\n    name: latest\n",
+		},
+		{
+			name:    "single config migrated",
+			format:  FormatYAML,
+			content: "channels:\n- name: latest\n  excludeRegex: rc\n",
+			want:    "apiVersion: v1\nchannels:\n- excludeRegexp: rc\n  name: latest\n",
+		},
+		{
+			name:    "JSON migrated",
+			format:  FormatJSON,
+			content: `{"k3s": {"channels": [{"name": "latest", "latestRegex": ".*"}]}}`,
+			want:    "{\n  \"apiVersion\": \"v1\",\n  \"k3s\": {\n    \"channels\": [\n      {\n        \"latestRegexp\": \".*\",\n        \"name\": \"latest\"\n      }\n    ]\n  }\n}\n",
+		},
+		{name: "unsupported version", format: FormatYAML, content: "apiVersion: v2\n", wantErr: `unsupported apiVersion "v2"`},
+		{name: "not a mapping", format: FormatYAML, content: "- k3s\n", wantErr: "config must be a mapping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Migrate([]byte(tt.content), tt.format)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.content
+			}
+			if string(got) != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestMigrateNewestVersion(t *testing.T) {
+	// Without earlier versions, files without a version are already in the
+	// newest one.
+	for _, content := range []string{"k3s:\n  channels: []\n", `{"rke2": {}, "k3s": {}}`} {
+		got, err := Migrate([]byte(content), DetectFormat("", "", []byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("migrated %q to %q", content, got)
+		}
+	}
+}
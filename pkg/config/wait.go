@@ -0,0 +1,126 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+type Wait interface {
+	Wait(ctx context.Context) bool
+}
+
+// ResultWait is implemented by waits that adapt to the outcome of each
+// configuration load. Result is called after every load with its error.
+type ResultWait interface {
+	Wait
+	Result(err error)
+}
+
+type DurationWait struct {
+	Duration time.Duration
+}
+
+func (d *DurationWait) Wait(ctx context.Context) bool {
+	return sleep(ctx, d.Duration)
+}
+
+// CronWait waits until the next time matched by a cron schedule.
+type CronWait struct {
+	Schedule cron.Schedule
+}
+
+// NewCronWait parses a standard five field cron expression, or a descriptor
+// such as "@hourly" or "@every 1m".
+func NewCronWait(expr string) (*CronWait, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &CronWait{Schedule: schedule}, nil
+}
+
+func (c *CronWait) Wait(ctx context.Context) bool {
+	return sleep(ctx, time.Until(c.Schedule.Next(time.Now())))
+}
+
+// ParseSchedule parses spec as a duration, such as "15m", or as a cron
+// expression, such as "*/5 * * * *" or "@hourly". The returned duration is
+// the interval between two consecutive runs.
+func ParseSchedule(spec string) (Wait, time.Duration, error) {
+	if duration, err := time.ParseDuration(spec); err == nil {
+		return &DurationWait{Duration: duration}, duration, nil
+	}
+
+	wait, err := NewCronWait(spec)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%q is neither a duration nor a cron expression: %w", spec, err)
+	}
+	next := wait.Schedule.Next(time.Now())
+	return wait, wait.Schedule.Next(next).Sub(next), nil
+}
+
+// BackoffWait retries failed loads after a delay that starts at Initial and
+// doubles with each consecutive failure, with jitter, up to Max. After a
+// successful load it defers to Interval.
+type BackoffWait struct {
+	Interval Wait
+	Initial  time.Duration
+	Max      time.Duration
+
+	lock     sync.Mutex
+	failures int
+}
+
+func (b *BackoffWait) Result(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err == nil {
+		b.failures = 0
+	} else {
+		b.failures++
+	}
+}
+
+func (b *BackoffWait) Wait(ctx context.Context) bool {
+	b.lock.Lock()
+	failures := b.failures
+	b.lock.Unlock()
+
+	if failures == 0 {
+		return b.Interval.Wait(ctx)
+	}
+	return sleep(ctx, b.delay(failures))
+}
+
+// delay returns a duration between half and all of the exponential backoff
+// for the given number of failures, so replicas failing together spread
+// their retries.
+func (b *BackoffWait) delay(failures int) time.Duration {
+	backoff := b.Initial
+	for i := 1; i < failures && backoff < b.Max; i++ {
+		backoff *= 2
+	}
+	if b.Max > 0 && backoff > b.Max {
+		backoff = b.Max
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffWaitDelay(t *testing.T) {
+	b := &BackoffWait{Initial: time.Second, Max: 10 * time.Second}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 3, want: 4 * time.Second},
+		{failures: 4, want: 8 * time.Second},
+		{failures: 5, want: 10 * time.Second},
+		{failures: 50, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			if got := b.delay(tt.failures); got < tt.want/2 || got > tt.want {
+				t.Fatalf("delay after %d failures = %s, want between %s and %s", tt.failures, got, tt.want/2, tt.want)
+			}
+		}
+	}
+
+	if got := (&BackoffWait{}).delay(3); got != 0 {
+		t.Errorf("delay without an initial backoff = %s, want 0", got)
+	}
+}
+
+// countWait is a Wait that returns at once, counting its calls.
+type countWait struct {
+	waits int
+}
+
+func (c *countWait) Wait(context.Context) bool {
+	c.waits++
+	return true
+}
+
+func TestBackoffWaitResult(t *testing.T) {
+	interval := &countWait{}
+	b := &BackoffWait{Interval: interval, Initial: time.Millisecond, Max: time.Millisecond}
+
+	tests := []struct {
+		name         string
+		err          error
+		wantInterval int
+	}{
+		{name: "success", wantInterval: 1},
+		{name: "failure", err: errors.New("down"), wantInterval: 1},
+		{name: "another failure", err: errors.New("down"), wantInterval: 1},
+		{name: "recovered", wantInterval: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Result(tt.err)
+			if !b.Wait(context.Background()) {
+				t.Fatal("wait was cancelled")
+			}
+			if interval.waits != tt.wantInterval {
+				t.Errorf("waited for the interval %d times, want %d", interval.waits, tt.wantInterval)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b = &BackoffWait{Initial: time.Hour, Max: time.Hour}
+	b.Result(errors.New("down"))
+	if b.Wait(ctx) {
+		t.Error("wait was not cancelled with its context")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec         string
+		wantInterval time.Duration
+		wantCron     bool
+		wantErr      bool
+	}{
+		{spec: "15m", wantInterval: 15 * time.Minute},
+		{spec: "0s", wantInterval: 0},
+		{spec: "@hourly", wantInterval: time.Hour, wantCron: true},
+		{spec: "@every 30s", wantInterval: 30 * time.Second, wantCron: true},
+		{spec: "*/5 * * * *", wantInterval: 5 * time.Minute, wantCron: true},
+		{spec: "30 * * * *", wantInterval: time.Hour, wantCron: true},
+		{spec: "15 minutes", wantErr: true},
+		{spec: "* * *", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			wait, interval, err := ParseSchedule(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %T", wait)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if interval != tt.wantInterval {
+				t.Errorf("interval %s, want %s", interval, tt.wantInterval)
+			}
+			if _, ok := wait.(*CronWait); ok != tt.wantCron {
+				t.Errorf("got %T, want a cron wait %v", wait, tt.wantCron)
+			}
+		})
+	}
+}
+
+func TestCronWait(t *testing.T) {
+	wait, err := NewCronWait("@every 1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !wait.Wait(ctx) {
+		t.Fatal("wait did not return at the next scheduled time")
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if wait.Wait(cancelled) {
+		t.Error("wait was not cancelled with its context")
+	}
+}
@@ -17,6 +17,8 @@ type Channel struct {
 	Latest        string `json:"latest,omitempty"`
 	LatestRegexp  string `json:"latestRegexp,omitempty"`
 	ExcludeRegexp string `json:"excludeRegexp,omitempty"`
+	// Provenance is where the channel was defined.
+	Provenance *Provenance `json:"-"`
 }
 
 type Release struct {
@@ -27,6 +29,8 @@ type Release struct {
 	AgentArgs               map[string]schemas.Field `json:"agentArgs,omitempty"`
 	FeatureVersions         map[string]string        `json:"featureVersions,omitempty"`
 	Charts                  map[string]Chart         `json:"charts,omitempty"`
+	// Provenance is where the release was defined.
+	Provenance *Provenance `json:"-"`
 }
 
 type Chart struct {
@@ -47,6 +51,8 @@ type AppDefaultsConfig struct {
 type AppDefault struct {
 	AppName  string    `json:"appName,omitempty"`
 	Defaults []Default `json:"defaults,omitempty"`
+	// Provenance is where the app default was defined.
+	Provenance *Provenance `json:"-"`
 }
 
 type Default struct {
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provenance is where a channel, release or app default was defined, and
+// how it reached the served config.
+type Provenance struct {
+	// Source is the URL of the config source the item was loaded from.
+	Source string `json:"source,omitempty"`
+	// File is the URL of the file defining the item: the source itself, a
+	// file it includes or an overlay.
+	File string `json:"file,omitempty"`
+	// Document is the index of the document defining the item, in a YAML
+	// File with several.
+	Document int `json:"document,omitempty"`
+	// Path is the path of the item within File, such as k3s.channels[0].
+	Path string `json:"path,omitempty"`
+	// Line is the line of File the item starts at, if known.
+	Line int `json:"line,omitempty"`
+	// SubKey is the config key the item is served for.
+	SubKey string `json:"subKey,omitempty"`
+	// InheritedFrom is the config key the item was inherited from with
+	// extends, if it was not defined for SubKey itself.
+	InheritedFrom string `json:"inheritedFrom,omitempty"`
+	// OverlaidBy are the overlays that changed the item, in order.
+	OverlaidBy []string `json:"overlaidBy,omitempty"`
+}
+
+// String describes the provenance in a form suitable for error messages,
+// such as "channels.yaml:12, inherited from k3s".
+func (p *Provenance) String() string {
+	location := p.File
+	switch {
+	case p.Line > 0:
+		location = fmt.Sprintf("%s:%d", location, p.Line)
+	case p.Document > 0:
+		location = fmt.Sprintf("%s at %s of document %d", location, p.Path, p.Document+1)
+	case p.Path != "":
+		location = fmt.Sprintf("%s at %s", location, p.Path)
+	}
+	if p.InheritedFrom != "" {
+		location += ", inherited from " + p.InheritedFrom
+	}
+	if len(p.OverlaidBy) > 0 {
+		location += ", overlaid by " + strings.Join(p.OverlaidBy, ", ")
+	}
+	return location
+}
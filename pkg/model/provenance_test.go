@@ -0,0 +1,29 @@
+package model
+
+import "testing"
+
+func TestProvenanceString(t *testing.T) {
+	tests := []struct {
+		name       string
+		provenance Provenance
+		want       string
+	}{
+		{name: "file", provenance: Provenance{File: "channels.yaml"}, want: "channels.yaml"},
+		{name: "line", provenance: Provenance{File: "channels.yaml", Path: "k3s.channels[0]", Line: 3}, want: "channels.yaml:3"},
+		{name: "path", provenance: Provenance{File: "channels.toml", Path: "k3s.channels[0]"}, want: "channels.toml at k3s.channels[0]"},
+		{name: "document", provenance: Provenance{File: "channels.yaml", Path: "k3s.channels[0]", Document: 1}, want: "channels.yaml at k3s.channels[0] of document 2"},
+		{name: "inherited", provenance: Provenance{File: "channels.yaml", Line: 3, InheritedFrom: "k3s"}, want: "channels.yaml:3, inherited from k3s"},
+		{
+			name:       "overlaid",
+			provenance: Provenance{File: "channels.yaml", Line: 3, InheritedFrom: "k3s", OverlaidBy: []string{"mirror.yaml", "pins.yaml"}},
+			want:       "channels.yaml:3, inherited from k3s, overlaid by mirror.yaml, pins.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.provenance.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package model
+
+import _ "embed"
+
+// APIVersion is the newest version of the channel config format, the one
+// Schema describes and the types of this package are read from.
+const APIVersion = "v1"
+
+// Schema is the JSON Schema of the channel config format.
+//
+//go:embed channels.schema.json
+var Schema []byte
@@ -2,10 +2,16 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rancher/apiserver/pkg/server"
 	"github.com/rancher/apiserver/pkg/store/apiroot"
@@ -15,11 +21,15 @@ import (
 	"github.com/rancher/channelserver/pkg/server/store/appdefault"
 	"github.com/rancher/channelserver/pkg/server/store/channel"
 	"github.com/rancher/channelserver/pkg/server/store/release"
+	"github.com/sirupsen/logrus"
 )
 
 func ListenAndServe(ctx context.Context, address string, configs map[string]*config.Config) error {
-	h := NewHandler(configs)
+	return Serve(ctx, address, NewHandler(configs))
+}
 
+// Serve serves h on address, logging requests.
+func Serve(ctx context.Context, address string, h http.Handler) error {
 	next := LoggingHandler(os.Stdout, h)
 	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		user := req.Header.Get("X-SUC-Cluster-ID")
@@ -32,34 +42,110 @@ func ListenAndServe(ctx context.Context, address string, configs map[string]*con
 	return http.ListenAndServe(address, handler)
 }
 
-func NewHandler(configs map[string]*config.Config) http.Handler {
-	var apiserver *server.Server
-	router := http.NewServeMux()
+// Handler serves the configuration of each of a set of path prefixes. The
+// prefixes can be added and removed while serving.
+type Handler struct {
+	lock     sync.RWMutex
+	prefixes map[string]http.Handler
+	// added are the prefixes in the order they were last added. The API
+	// server of the last one serves the API root.
+	added []string
+}
+
+func NewHandler(configs map[string]*config.Config) *Handler {
+	h := &Handler{
+		prefixes: map[string]http.Handler{},
+	}
 	for prefix, config := range configs {
-		apiserver = server.DefaultAPIServer()
-		apiserver.Schemas.MustImportAndCustomize(model.Channel{}, func(schema *types.APISchema) {
-			schema.Store = channel.New(config)
-			schema.CollectionMethods = []string{http.MethodGet}
-			schema.ResourceMethods = []string{http.MethodGet}
-		})
-		apiserver.Schemas.MustImportAndCustomize(model.Release{}, func(schema *types.APISchema) {
-			schema.Store = release.New(config)
-			schema.CollectionMethods = []string{http.MethodGet}
-		})
-		apiserver.Schemas.MustImportAndCustomize(model.AppDefault{}, func(schema *types.APISchema) {
-			schema.Store = appdefault.New(config)
-			schema.CollectionMethods = []string{http.MethodGet}
-		})
-		prefix = strings.Trim(prefix, "/")
-		apiroot.Register(apiserver.Schemas, []string{prefix})
-		router.Handle("/"+prefix+"/{type}", setPathValues(apiserver, "", prefix))
-		router.Handle("/"+prefix+"/{type}/{name}", setPathValues(apiserver, "", prefix))
+		h.Add(prefix, config)
+	}
+	return h
+}
+
+// Add serves config at prefix, replacing the config served at it before.
+func (h *Handler) Add(prefix string, config *config.Config) {
+	apiserver := server.DefaultAPIServer()
+	apiserver.Schemas.MustImportAndCustomize(model.Channel{}, func(schema *types.APISchema) {
+		schema.Store = channel.New(config)
+		schema.CollectionMethods = []string{http.MethodGet}
+		schema.ResourceMethods = []string{http.MethodGet}
+	})
+	apiserver.Schemas.MustImportAndCustomize(model.Release{}, func(schema *types.APISchema) {
+		schema.Store = release.New(config)
+		schema.CollectionMethods = []string{http.MethodGet}
+	})
+	apiserver.Schemas.MustImportAndCustomize(model.AppDefault{}, func(schema *types.APISchema) {
+		schema.Store = appdefault.New(config)
+		schema.CollectionMethods = []string{http.MethodGet}
+	})
+	prefix = strings.Trim(prefix, "/")
+	apiroot.Register(apiserver.Schemas, []string{prefix})
+
+	router := http.NewServeMux()
+	handler := setStatusHeaders(config, setPathValues(apiserver, "", prefix))
+	router.Handle("GET /"+prefix+"/debug", setStatusHeaders(config, debugHandler(config)))
+	router.Handle("/"+prefix+"/{type}", handler)
+	router.Handle("/"+prefix+"/{type}/{name}", handler)
+	router.Handle("/{$}", setPathValues(apiserver, "apiRoot", ""))
+	router.Handle("/{name}", setPathValues(apiserver, "apiRoot", ""))
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.prefixes[prefix] = router
+	h.added = append(slices.DeleteFunc(h.added, func(added string) bool {
+		return added == prefix
+	}), prefix)
+}
+
+// Remove stops serving prefix.
+func (h *Handler) Remove(prefix string) {
+	prefix = strings.Trim(prefix, "/")
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.prefixes, prefix)
+	h.added = slices.DeleteFunc(h.added, func(added string) bool {
+		return added == prefix
+	})
+}
+
+// root returns the prefix whose API server serves the API root. It must be
+// called while holding h.lock.
+func (h *Handler) root() string {
+	if len(h.added) == 0 {
+		return ""
 	}
-	if apiserver != nil {
-		router.Handle("/{$}", setPathValues(apiserver, "apiRoot", ""))
-		router.Handle("/{name}", setPathValues(apiserver, "apiRoot", ""))
+	return h.added[len(h.added)-1]
+}
+
+// Prefixes returns the sorted prefixes being served.
+func (h *Handler) Prefixes() []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	prefixes := make([]string, 0, len(h.prefixes))
+	for prefix := range h.prefixes {
+		prefixes = append(prefixes, prefix)
 	}
-	return router
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.lock.RLock()
+	handler := h.prefixes[h.root()]
+	longest := -1
+	for prefix, prefixHandler := range h.prefixes {
+		if strings.HasPrefix(r.URL.Path, "/"+prefix+"/") && len(prefix) > longest {
+			handler, longest = prefixHandler, len(prefix)
+		}
+	}
+	h.lock.RUnlock()
+
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	handler.ServeHTTP(w, r)
 }
 
 func setPathValues(handler http.Handler, typeName, prefix string) http.Handler {
@@ -73,3 +159,104 @@ func setPathValues(handler http.Handler, typeName, prefix string) http.Handler {
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// debugView is the status of the configuration served for a prefix, the
+// health of its sources and the items it serves.
+type debugView struct {
+	Status  config.Status         `json:"status"`
+	Sources []config.SourceHealth `json:"sources"`
+	Items   []debugItem           `json:"items"`
+}
+
+// debugItem is a served channel, release or app default with where it was
+// defined.
+type debugItem struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id"`
+	Object     interface{}       `json:"object"`
+	Provenance *model.Provenance `json:"provenance,omitempty"`
+}
+
+// debugHandler serves the status of the configuration served for a prefix,
+// the health of its sources and the provenance of each of its channels,
+// releases and app defaults.
+func debugHandler(config *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		view := debugView{
+			Status:  config.Status(),
+			Sources: config.SourceHealth(),
+			Items:   []debugItem{},
+		}
+		view.Status.Source = redactQueries(view.Status.Source)
+		for i := range view.Sources {
+			view.Sources[i].URL = redactQueries(view.Sources[i].URL)
+			view.Sources[i].LastError = redactQueries(view.Sources[i].LastError)
+		}
+		for _, channel := range config.ChannelsConfig().Channels {
+			view.Items = append(view.Items, debugItem{Type: "channel", ID: channel.Name, Object: channel, Provenance: redactProvenance(config.Provenance(channel.Provenance))})
+		}
+		for _, release := range config.ReleasesConfig().Releases {
+			view.Items = append(view.Items, debugItem{Type: "release", ID: release.Version, Object: release, Provenance: redactProvenance(config.Provenance(release.Provenance))})
+		}
+		for _, appDefault := range config.AppDefaultsConfig().AppDefaults {
+			view.Items = append(view.Items, debugItem{Type: "appDefault", ID: appDefault.AppName, Object: appDefault, Provenance: redactProvenance(config.Provenance(appDefault.Provenance))})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(view); err != nil {
+			logrus.Errorf("Failed to write debug view: %v", err)
+		}
+	})
+}
+
+// urlQuery matches a URL with a query, holding the URL without it and the
+// punctuation and space following it.
+var urlQuery = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'?]*)\?[^\s"']*?([:;,.)]*)([\s"']|$)`)
+
+// redactQueries leaves the queries out of a URL or of the URLs in a message,
+// as they may hold credentials, such as the signature of a presigned URL.
+func redactQueries(s string) string {
+	return urlQuery.ReplaceAllString(s, "$1$2$3")
+}
+
+// redactProvenance returns p with the queries left out of its URLs.
+func redactProvenance(p *model.Provenance) *model.Provenance {
+	if p == nil {
+		return nil
+	}
+	redacted := *p
+	redacted.Source = redactQueries(p.Source)
+	redacted.File = redactQueries(p.File)
+	redacted.OverlaidBy = make([]string, len(p.OverlaidBy))
+	for i, overlay := range p.OverlaidBy {
+		redacted.OverlaidBy[i] = redactQueries(overlay)
+	}
+	return &redacted
+}
+
+// setStatusHeaders reports the state of the configuration served for a
+// prefix in the response headers.
+func setStatusHeaders(config *config.Config, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := config.Status()
+		if status.Stale {
+			w.Header().Set("X-Channelserver-Stale", "true")
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+		}
+		if status.Source != "" {
+			w.Header().Set("X-Channelserver-Source", redactQueries(status.Source))
+		}
+		if status.Digest != "" {
+			w.Header().Set("X-Channelserver-Digest", status.Digest)
+		}
+		if status.Revision != "" {
+			w.Header().Set("X-Channelserver-Revision", status.Revision)
+		}
+		if !status.LoadedAt.IsZero() {
+			w.Header().Set("X-Channelserver-Loaded-At", status.LoadedAt.UTC().Format(time.RFC3339))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/channelserver/pkg/config"
+)
+
+const testConfig = `k3s:
+  channels:
+  - name: stable
+    latest: v1.30.4+k3s1
+`
+
+// newTestConfig serves testConfig from an HTTP server, at a URL with query,
+// behind a source at a URL with query that cannot be connected to.
+func newTestConfig(t *testing.T) (*config.Config, string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testConfig))
+	}))
+	t.Cleanup(server.Close)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	var sources []config.Source
+	for _, url := range []string{closed.URL, server.URL} {
+		source, err := config.NewSource(url + "/channels.yaml?X-Amz-Signature=secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, source)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	c, err := config.StartConfig(ctx, "k3s", nil, sources, config.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, server.URL + "/channels.yaml"
+}
+
+func TestHandlerRoot(t *testing.T) {
+	c, _ := newTestConfig(t)
+	h := NewHandler(nil)
+
+	tests := []struct {
+		name     string
+		add      string
+		remove   string
+		wantRoot string
+	}{
+		{name: "first", add: "v1-release", wantRoot: "v1-release"},
+		{name: "last added", add: "v1-k3s-release", wantRoot: "v1-k3s-release"},
+		{name: "earlier name added last", add: "a", wantRoot: "a"},
+		{name: "re-added", add: "v1-release", wantRoot: "v1-release"},
+		{name: "root removed", remove: "v1-release", wantRoot: "a"},
+		{name: "other removed", remove: "v1-k3s-release", wantRoot: "a"},
+		{name: "all removed", remove: "a", wantRoot: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.add != "" {
+				h.Add(tt.add, c)
+			}
+			if tt.remove != "" {
+				h.Remove(tt.remove)
+			}
+
+			h.lock.RLock()
+			root := h.root()
+			h.lock.RUnlock()
+			if root != tt.wantRoot {
+				t.Errorf("root %q, want %q", root, tt.wantRoot)
+			}
+
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+			if tt.wantRoot == "" {
+				if resp.Code != http.StatusNotFound {
+					t.Errorf("status %d without prefixes, want 404", resp.Code)
+				}
+				return
+			}
+			if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"id":"`+tt.wantRoot+`"`) {
+				t.Errorf("API root %d: %s, want the root of %s", resp.Code, resp.Body, tt.wantRoot)
+			}
+		})
+	}
+}
+
+func TestHandlerStatusHeaders(t *testing.T) {
+	c, source := newTestConfig(t)
+	h := NewHandler(map[string]*config.Config{"v1-release": c})
+
+	for _, path := range []string{"/v1-release/channels", "/v1-release/debug"} {
+		t.Run(path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, path, nil))
+			if resp.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", resp.Code, resp.Body)
+			}
+			if got := resp.Header().Get("X-Channelserver-Source"); got != source {
+				t.Errorf("X-Channelserver-Source %s, want %s", got, source)
+			}
+			if got, want := resp.Header().Get("X-Channelserver-Digest"), c.Status().Digest; got != want {
+				t.Errorf("X-Channelserver-Digest %s, want %s", got, want)
+			}
+			if resp.Header().Get("X-Channelserver-Stale") != "" {
+				t.Error("live config reported as stale")
+			}
+		})
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	c, _ := newTestConfig(t)
+	resp := httptest.NewRecorder()
+	debugHandler(c).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/v1-release/debug", nil))
+
+	if strings.Contains(resp.Body.String(), "secret") {
+		t.Errorf("debug view shows the query of source URLs: %s", resp.Body)
+	}
+	var view struct {
+		Sources []config.SourceHealth `json:"sources"`
+		Items   []debugItem           `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Sources) != 2 || view.Sources[0].LastError == "" || !view.Sources[1].Healthy {
+		t.Errorf("sources %+v, want a failed and a healthy source", view.Sources)
+	}
+	if len(view.Items) != 1 || view.Items[0].Type != "channel" || view.Items[0].ID != "stable" {
+		t.Errorf("items %+v, want the stable channel", view.Items)
+	}
+}
+
+func TestRedactQueries(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "https://example.com/channels.yaml", want: "https://example.com/channels.yaml"},
+		{value: "https://example.com/channels.yaml?X-Amz-Signature=secret", want: "https://example.com/channels.yaml"},
+		{value: "git+https://github.com/org/config.git?ref=main&path=channels.yaml", want: "git+https://github.com/org/config.git"},
+		{
+			value: `Get "https://example.com/channels.yaml?X-Amz-Signature=secret": dial tcp: connection refused`,
+			want:  `Get "https://example.com/channels.yaml": dial tcp: connection refused`,
+		},
+		{
+			value: "s3://bucket/channels.yaml?region=eu: status 403, falling back to https://mirror.example.com/channels.yaml?token=secret",
+			want:  "s3://bucket/channels.yaml: status 403, falling back to https://mirror.example.com/channels.yaml",
+		},
+		{value: "invalid config: k3s.channels[0].latest: is this a version?", want: "invalid config: k3s.channels[0].latest: is this a version?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := redactQueries(tt.value); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
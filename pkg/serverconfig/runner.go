@@ -0,0 +1,331 @@
+package serverconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/channelserver/pkg/config"
+	"github.com/rancher/channelserver/pkg/server"
+	"github.com/sirupsen/logrus"
+)
+
+// Runner serves the configs of a set of prefixes, starting, restarting and
+// stopping them as the prefixes are changed.
+type Runner struct {
+	Handler     *server.Handler
+	HTTPOptions config.HTTPOptions
+	Verifier    *config.Verifier
+	CacheDir    string
+	// Retry is the initial delay before retrying a failed load, or 0 to
+	// wait for the refresh schedule.
+	Retry time.Duration
+	// IncludeOrigins are the origins files can be included from in addition
+	// to the origin of the including file.
+	IncludeOrigins []string
+	// Variables configure the references to the environment and to files
+	// substituted in configs.
+	Variables config.Variables
+
+	applyLock sync.Mutex
+	running   map[string]*running
+
+	lock sync.Mutex
+	// owners are the runs serving each prefix. Prefixes served for a single
+	// key are not taken over by keys discovered for a prefix serving every
+	// key.
+	owners map[string]*running
+}
+
+type running struct {
+	prefix Prefix
+	cancel context.CancelFunc
+
+	lock sync.Mutex
+	// paused is set while the prefixes served for every key must not be
+	// changed, as the run is being replaced or was stopped.
+	paused bool
+	// served are the prefixes served for every key, by the prefix.
+	served map[string]context.CancelFunc
+}
+
+func (run *running) pause(paused bool) {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+	run.paused = paused
+}
+
+// Apply changes the served prefixes to prefixes. Prefixes whose settings
+// have not changed are left running, and a changed prefix keeps serving its
+// previous config until the new one has been loaded, or if it cannot be.
+func (r *Runner) Apply(ctx context.Context, prefixes []Prefix) error {
+	r.applyLock.Lock()
+	defer r.applyLock.Unlock()
+
+	if r.running == nil {
+		r.running = map[string]*running{}
+	}
+
+	wanted := map[string]Prefix{}
+	for _, prefix := range prefixes {
+		prefix.Prefix = strings.Trim(prefix.Prefix, "/")
+		wanted[prefix.Prefix] = prefix
+	}
+
+	for name, run := range r.running {
+		if _, ok := wanted[name]; !ok {
+			r.stop(run)
+			delete(r.running, name)
+			logrus.Infof("Stopped serving /%s", name)
+		}
+	}
+
+	var errs []error
+	// Single keys are started first, so that they keep their prefixes when
+	// every key is served as well.
+	for _, allKeys := range []bool{false, true} {
+		for _, prefix := range prefixes {
+			prefix = wanted[strings.Trim(prefix.Prefix, "/")]
+			if (prefix.Key == config.AllKeys) != allKeys {
+				continue
+			}
+			previous := r.running[prefix.Prefix]
+			if previous != nil && reflect.DeepEqual(previous.prefix, prefix) {
+				continue
+			}
+			// The keys of a previous run serving every key are left as
+			// they are while the new run starts, so that it does not serve
+			// a prefix again once the new run took it over.
+			if previous != nil && allKeys {
+				previous.pause(true)
+			}
+			run, err := r.start(ctx, prefix)
+			if err != nil {
+				if previous != nil && allKeys {
+					previous.pause(false)
+				}
+				errs = append(errs, fmt.Errorf("failed to serve /%s: %w", prefix.Prefix, err))
+				continue
+			}
+			// The prefixes the new run serves were taken over from the
+			// previous one, which stops serving the others.
+			if previous != nil {
+				r.stop(previous)
+			}
+			r.running[prefix.Prefix] = run
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops serving all prefixes.
+func (r *Runner) Close() {
+	r.applyLock.Lock()
+	defer r.applyLock.Unlock()
+
+	for name, run := range r.running {
+		r.stop(run)
+		delete(r.running, name)
+	}
+}
+
+func (r *Runner) start(ctx context.Context, prefix Prefix) (*running, error) {
+	pins := map[string]config.DigestPin{}
+	sources, err := newSources(prefix.Sources, r.HTTPOptions, pins)
+	if err != nil {
+		return nil, err
+	}
+	overlays, err := newSources(prefix.Overlays, r.HTTPOptions, pins)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.newWait(prefix); err != nil {
+		return nil, err
+	}
+	validation, err := config.ParseValidationMode(prefix.Validation)
+	if err != nil {
+		return nil, err
+	}
+	opts := config.Options{
+		ChannelServerVersion: prefix.ChannelServerVersion,
+		AppName:              prefix.AppName,
+		GitHubToken:          prefix.GitHubToken,
+		CacheDir:             r.CacheDir,
+		Verifier:             r.Verifier,
+		Pins:                 pins,
+		Overlays:             overlays,
+		Validation:           validation,
+		IncludeOrigins:       r.IncludeOrigins,
+		Variables:            r.Variables,
+	}
+	for _, overlay := range overlays {
+		logrus.Infof("Applying overlay %s to channel config for /%s", overlay.URL(), prefix.Prefix)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	run := &running{
+		prefix: prefix,
+		cancel: cancel,
+	}
+	if prefix.Key == config.AllKeys {
+		if err := r.serveAllKeys(runCtx, run, sources, opts); err != nil {
+			r.stop(run)
+			return nil, err
+		}
+		return run, nil
+	}
+
+	wait, _ := r.newWait(prefix)
+	c, err := config.StartConfig(runCtx, prefix.Key, wait, sources, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.serve(prefix.Prefix, run, c)
+	logrus.Infof("Serving channels from %v with subkey %q at /%s", sourceURLs(sources), prefix.Key, prefix.Prefix)
+	return run, nil
+}
+
+// serve serves c at prefix for run.
+func (r *Runner) serve(prefix string, run *running, c *config.Config) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.owners == nil {
+		r.owners = map[string]*running{}
+	}
+	r.owners[prefix] = run
+	r.Handler.Add(prefix, c)
+}
+
+// unserve stops serving prefix, unless another run took it over from run.
+func (r *Runner) unserve(prefix string, run *running) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.owners[prefix] == run {
+		delete(r.owners, prefix)
+		r.Handler.Remove(prefix)
+	}
+}
+
+// explicit reports whether prefix is served for a single key.
+func (r *Runner) explicit(prefix string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	owner := r.owners[prefix]
+	return owner != nil && owner.prefix.Key != config.AllKeys
+}
+
+func (r *Runner) stop(run *running) {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+
+	run.paused = true
+	run.cancel()
+	if run.prefix.Key != config.AllKeys {
+		r.unserve(run.prefix.Prefix, run)
+		return
+	}
+	for prefix, cancel := range run.served {
+		cancel()
+		r.unserve(prefix, run)
+	}
+}
+
+// serveAllKeys serves every top-level key of the config at the prefix of
+// run with * replaced by the key, except for prefixes serving a single key.
+// Prefixes are added and removed as keys are added to and removed from the
+// config. An error is returned if the keys cannot be loaded initially.
+func (r *Runner) serveAllKeys(ctx context.Context, run *running, sources []config.Source, opts config.Options) error {
+	wait, _ := r.newWait(run.prefix)
+	run.served = map[string]context.CancelFunc{}
+	watcher := config.NewKeyWatcher(sources, opts)
+	return watcher.Watch(ctx, wait, func(keys []string) {
+		run.lock.Lock()
+		defer run.lock.Unlock()
+		if run.paused {
+			return
+		}
+
+		found := map[string]bool{}
+		for _, key := range keys {
+			prefix := strings.Trim(strings.ReplaceAll(run.prefix.Prefix, config.AllKeys, key), "/")
+			if r.explicit(prefix) {
+				if cancel := run.served[prefix]; cancel != nil {
+					cancel()
+					delete(run.served, prefix)
+				}
+				continue
+			}
+			found[prefix] = true
+			if run.served[prefix] != nil {
+				continue
+			}
+
+			keyCtx, cancel := context.WithCancel(ctx)
+			c, err := watcher.StartConfig(keyCtx, key)
+			if err != nil {
+				cancel()
+				logrus.Errorf("Failed to serve config key %s at /%s: %v", key, prefix, err)
+				continue
+			}
+			run.served[prefix] = cancel
+			r.serve(prefix, run, c)
+			logrus.Infof("Serving channels from %v with subkey %q at /%s", sourceURLs(sources), key, prefix)
+		}
+
+		for prefix, cancel := range run.served {
+			if !found[prefix] {
+				r.unserve(prefix, run)
+				cancel()
+				delete(run.served, prefix)
+				logrus.Infof("Stopped serving /%s, its config key was removed", prefix)
+			}
+		}
+	})
+}
+
+// newWait returns the wait between reloads of the config of prefix.
+func (r *Runner) newWait(prefix Prefix) (config.Wait, error) {
+	wait, intval, err := config.ParseSchedule(prefix.Refresh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse refresh schedule: %w", err)
+	}
+	if r.Retry > 0 {
+		wait = &config.BackoffWait{Interval: wait, Initial: r.Retry, Max: intval}
+	}
+	return wait, nil
+}
+
+// newSources creates the sources described by sourceConfigs, adding their
+// digest pins to pins.
+func newSources(sourceConfigs []Source, httpOptions config.HTTPOptions, pins map[string]config.DigestPin) ([]config.Source, error) {
+	var sources []config.Source
+	for _, sourceConfig := range sourceConfigs {
+		source, err := sourceConfig.NewSource(httpOptions)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+
+		pin, err := sourceConfig.Pin(httpOptions)
+		if err != nil {
+			return nil, err
+		}
+		if pin != nil {
+			pins[source.URL()] = *pin
+		}
+	}
+	return sources, nil
+}
+
+func sourceURLs(sources []config.Source) []string {
+	urls := make([]string, 0, len(sources))
+	for _, source := range sources {
+		urls = append(urls, source.URL())
+	}
+	return urls
+}
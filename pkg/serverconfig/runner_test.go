@@ -0,0 +1,151 @@
+package serverconfig
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rancher/channelserver/pkg/server"
+)
+
+const (
+	k3sConfig = `k3s:
+  channels:
+  - name: stable
+    latest: v1.30.4+k3s1
+`
+	productsConfig = `k3s:
+  channels:
+  - name: stable
+    latest: v1.30.5+k3s1
+rke2:
+  channels:
+  - name: stable
+    latest: v1.30.4+rke2r1
+`
+	otherProductsConfig = `k3s:
+  channels:
+  - name: stable
+    latest: v1.31.0+k3s1
+k3k:
+  channels:
+  - name: stable
+    latest: v0.2.0
+`
+)
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// latest returns the latest release of the stable channel served at prefix,
+// which it redirects to.
+func latest(h http.Handler, prefix string) string {
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/"+prefix+"/channels/stable", nil))
+	if resp.Code != http.StatusFound {
+		return ""
+	}
+	return strings.TrimPrefix(resp.Header().Get("Location"), "/")
+}
+
+func TestRunnerApply(t *testing.T) {
+	dir := t.TempDir()
+	k3s := writeConfig(t, dir, "k3s.yaml", k3sConfig)
+	products := writeConfig(t, dir, "products.yaml", productsConfig)
+	otherProducts := writeConfig(t, dir, "other.yaml", otherProductsConfig)
+
+	explicit := Prefix{Prefix: "v1-k3s-release", Key: "k3s", Sources: []Source{{URL: k3s}}, Refresh: "1h"}
+	allKeys := func(path string) Prefix {
+		return Prefix{Prefix: "v1-*-release", Key: "*", Sources: []Source{{URL: path}}, Refresh: "1h"}
+	}
+
+	r := &Runner{Handler: server.NewHandler(nil)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer r.Close()
+
+	tests := []struct {
+		name    string
+		apply   []Prefix
+		wantErr bool
+		// want is the latest stable release served at each prefix.
+		want map[string]string
+	}{
+		{
+			name:  "single key",
+			apply: []Prefix{{Prefix: "v1-k3s-release", Key: "k3s", Sources: []Source{{URL: products}}, Refresh: "1h"}},
+			want:  map[string]string{"v1-k3s-release": "v1.30.5+k3s1"},
+		},
+		{
+			name:  "changed single key",
+			apply: []Prefix{explicit},
+			want:  map[string]string{"v1-k3s-release": "v1.30.4+k3s1"},
+		},
+		{
+			name:  "every key, except an explicit one",
+			apply: []Prefix{explicit, allKeys(products)},
+			want:  map[string]string{"v1-k3s-release": "v1.30.4+k3s1", "v1-rke2-release": "v1.30.4+rke2r1"},
+		},
+		{
+			name:    "every key from a source that cannot be loaded",
+			apply:   []Prefix{explicit, allKeys(filepath.Join(dir, "missing.yaml"))},
+			wantErr: true,
+			want:    map[string]string{"v1-k3s-release": "v1.30.4+k3s1", "v1-rke2-release": "v1.30.4+rke2r1"},
+		},
+		{
+			name:  "every key from another source",
+			apply: []Prefix{explicit, allKeys(otherProducts)},
+			want:  map[string]string{"v1-k3s-release": "v1.30.4+k3s1", "v1-k3k-release": "v0.2.0"},
+		},
+		{
+			// The explicit key is only taken over when the keys are
+			// loaded again.
+			name:  "explicit key removed",
+			apply: []Prefix{allKeys(otherProducts)},
+			want:  map[string]string{"v1-k3k-release": "v0.2.0"},
+		},
+		{
+			name:  "every key, changed",
+			apply: []Prefix{allKeys(products)},
+			want:  map[string]string{"v1-k3s-release": "v1.30.5+k3s1", "v1-rke2-release": "v1.30.4+rke2r1"},
+		},
+		{
+			name:  "every key removed",
+			apply: []Prefix{explicit},
+			want:  map[string]string{"v1-k3s-release": "v1.30.4+k3s1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.Apply(ctx, tt.apply)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var want []string
+			for prefix := range tt.want {
+				want = append(want, prefix)
+			}
+			slices.Sort(want)
+			if got := r.Handler.Prefixes(); !slices.Equal(got, want) {
+				t.Errorf("serving %v, want %v", got, want)
+			}
+			for prefix, version := range tt.want {
+				if got := latest(r.Handler, prefix); got != version {
+					t.Errorf("serving %q at /%s, want %s", got, prefix, version)
+				}
+			}
+		})
+	}
+}
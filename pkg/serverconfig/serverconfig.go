@@ -0,0 +1,288 @@
+package serverconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rancher/channelserver/pkg/config"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the server configuration file, for settings that cannot be
+// expressed with command line flags.
+type Config struct {
+	// Sources replace the --url flags if set.
+	Sources []Source `json:"sources,omitempty"`
+	// Overlays replace the --overlay flags if set.
+	Overlays []Source `json:"overlays,omitempty"`
+	// Prefixes replace the --config-key and --path-prefix flags if set.
+	Prefixes []Prefix `json:"prefixes,omitempty"`
+}
+
+// Prefix is a path prefix to serve and the settings of the config served at
+// it. Settings that are not set default to those of the server.
+type Prefix struct {
+	Prefix string `json:"prefix"`
+	// Key is the top-level key of the config to serve, or * to serve every
+	// key at the prefix with * replaced by the key.
+	Key                  string   `json:"key,omitempty"`
+	Sources              []Source `json:"sources,omitempty"`
+	Overlays             []Source `json:"overlays,omitempty"`
+	ChannelServerVersion string   `json:"channelServerVersion,omitempty"`
+	AppName              string   `json:"appName,omitempty"`
+	GitHubToken          string   `json:"githubToken,omitempty"`
+	GitHubTokenFile      string   `json:"githubTokenFile,omitempty"`
+	// Refresh is the refresh schedule, a duration or a cron expression.
+	Refresh string `json:"refresh,omitempty"`
+	// Validation is how a config that does not match the schema is
+	// handled, strict or warn.
+	Validation string `json:"validation,omitempty"`
+}
+
+// Source is a channel config source and the settings used to fetch it.
+type Source struct {
+	URL  string `json:"url"`
+	HTTP *HTTP  `json:"http,omitempty"`
+	// Digest pins the content of the source to a SHA-256 digest, given as
+	// sha256:<hex>.
+	Digest string `json:"digest,omitempty"`
+	// DigestURL is a source to read the pinned digest from instead.
+	DigestURL string `json:"digestURL,omitempty"`
+}
+
+// HTTP configures the requests of sources fetched over HTTP. Fields that are
+// set override the defaults given with the --http-* flags.
+type HTTP struct {
+	Timeout         Duration          `json:"timeout,omitempty"`
+	CAFile          string            `json:"caFile,omitempty"`
+	CertFile        string            `json:"certFile,omitempty"`
+	KeyFile         string            `json:"keyFile,omitempty"`
+	Proxy           string            `json:"proxy,omitempty"`
+	BearerToken     string            `json:"bearerToken,omitempty"`
+	BearerTokenFile string            `json:"bearerTokenFile,omitempty"`
+	Username        string            `json:"username,omitempty"`
+	Password        string            `json:"password,omitempty"`
+	PasswordFile    string            `json:"passwordFile,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	MaxBodySize     int64             `json:"maxBodySize,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "10s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %w", err)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Load reads the server configuration file at path. Unknown fields are
+// rejected so that typos do not go unnoticed.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &Config{}
+	if err := yaml.UnmarshalStrict(data, result); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := validateSources(result.Sources, "sources"); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := validateSources(result.Overlays, "overlays"); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, prefix := range result.Prefixes {
+		name := strings.Trim(prefix.Prefix, "/")
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("failed to parse %s: prefixes[%d] has no prefix", path, i)
+		case seen[name]:
+			return nil, fmt.Errorf("failed to parse %s: prefixes[%d] repeats prefix %s", path, i, name)
+		case prefix.Key == config.AllKeys && !strings.Contains(name, config.AllKeys):
+			return nil, fmt.Errorf("failed to parse %s: prefixes[%d] must contain %s to serve every key", path, i, config.AllKeys)
+		case prefix.GitHubToken != "" && prefix.GitHubTokenFile != "":
+			return nil, fmt.Errorf("failed to parse %s: prefixes[%d] sets both githubToken and githubTokenFile", path, i)
+		}
+		if _, err := config.ParseValidationMode(prefix.Validation); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: prefixes[%d]: %w", path, i, err)
+		}
+		seen[name] = true
+		if err := validateSources(prefix.Sources, fmt.Sprintf("prefixes[%d].sources", i)); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if err := validateSources(prefix.Overlays, fmt.Sprintf("prefixes[%d].overlays", i)); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	return result, nil
+}
+
+// Defaults returns defaults with the sources and overlays replaced by those
+// of the configuration, if it has any.
+func (c *Config) Defaults(defaults Prefix) Prefix {
+	if len(c.Sources) > 0 {
+		defaults.Sources = c.Sources
+	}
+	if len(c.Overlays) > 0 {
+		defaults.Overlays = c.Overlays
+	}
+	return defaults
+}
+
+// ServedPrefixes returns the prefixes of the configuration with settings
+// that are not set taken from the sources and overlays of the
+// configuration, or from defaults. If the configuration has no prefixes nil
+// is returned.
+func (c *Config) ServedPrefixes(defaults Prefix) ([]Prefix, error) {
+	defaults = c.Defaults(defaults)
+
+	var result []Prefix
+	for _, prefix := range c.Prefixes {
+		if len(prefix.Sources) == 0 {
+			prefix.Sources = defaults.Sources
+		}
+		if len(prefix.Overlays) == 0 {
+			prefix.Overlays = defaults.Overlays
+		}
+		prefix.ChannelServerVersion = override(defaults.ChannelServerVersion, prefix.ChannelServerVersion)
+		prefix.AppName = override(defaults.AppName, prefix.AppName)
+		prefix.Refresh = override(defaults.Refresh, prefix.Refresh)
+		prefix.Validation = override(defaults.Validation, prefix.Validation)
+		if prefix.GitHubTokenFile != "" {
+			token, err := readSecret(prefix.GitHubTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			prefix.GitHubToken = token
+			prefix.GitHubTokenFile = ""
+		}
+		prefix.GitHubToken = override(defaults.GitHubToken, prefix.GitHubToken)
+		result = append(result, prefix)
+	}
+	return result, nil
+}
+
+func validateSources(sources []Source, field string) error {
+	for i, source := range sources {
+		if source.URL == "" {
+			return fmt.Errorf("%s[%d] has no url", field, i)
+		}
+		if source.Digest != "" && source.DigestURL != "" {
+			return fmt.Errorf("%s[%d] sets both digest and digestURL", field, i)
+		}
+		if source.Digest != "" {
+			if _, err := config.ParseDigest(source.Digest); err != nil {
+				return fmt.Errorf("%s[%d]: %w", field, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NewSource creates the source, applying its HTTP settings over defaults.
+func (s *Source) NewSource(defaults config.HTTPOptions) (config.Source, error) {
+	opts, err := s.HTTP.Options(defaults)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP settings for %s: %w", s.URL, err)
+	}
+	return config.NewSourceWithHTTPOptions(s.URL, opts)
+}
+
+// Pin returns the digest pin of the source, if it has one. The digest URL
+// is fetched with the default HTTP options.
+func (s *Source) Pin(defaults config.HTTPOptions) (*config.DigestPin, error) {
+	switch {
+	case s.Digest != "":
+		return &config.DigestPin{Digest: s.Digest}, nil
+	case s.DigestURL != "":
+		source, err := config.NewSourceWithHTTPOptions(s.DigestURL, defaults)
+		if err != nil {
+			return nil, err
+		}
+		return &config.DigestPin{Source: source}, nil
+	}
+	return nil, nil
+}
+
+// Options returns defaults with the fields set in h replaced.
+func (h *HTTP) Options(defaults config.HTTPOptions) (config.HTTPOptions, error) {
+	opts := defaults
+	if h == nil {
+		return opts, nil
+	}
+
+	if h.Timeout.Duration != 0 {
+		opts.Timeout = h.Timeout.Duration
+	}
+	opts.CAFile = override(opts.CAFile, h.CAFile)
+	opts.CertFile = override(opts.CertFile, h.CertFile)
+	opts.KeyFile = override(opts.KeyFile, h.KeyFile)
+	opts.Proxy = override(opts.Proxy, h.Proxy)
+	opts.Username = override(opts.Username, h.Username)
+	opts.Password = override(opts.Password, h.Password)
+	opts.BearerToken = override(opts.BearerToken, h.BearerToken)
+	if h.MaxBodySize != 0 {
+		opts.MaxBodySize = h.MaxBodySize
+	}
+
+	if h.BearerTokenFile != "" {
+		token, err := readSecret(h.BearerTokenFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.BearerToken = token
+	}
+	if h.PasswordFile != "" {
+		password, err := readSecret(h.PasswordFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.Password = password
+	}
+
+	if len(h.Headers) > 0 {
+		headers := map[string]string{}
+		for name, value := range defaults.Headers {
+			headers[name] = value
+		}
+		for name, value := range h.Headers {
+			headers[name] = value
+		}
+		opts.Headers = headers
+	}
+	return opts, nil
+}
+
+func override(value, with string) string {
+	if with != "" {
+		return with
+	}
+	return value
+}
+
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
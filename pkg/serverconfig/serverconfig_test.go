@@ -0,0 +1,175 @@
+package serverconfig
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/channelserver/pkg/config"
+)
+
+func TestLoad(t *testing.T) {
+	const digest = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			content: `sources:
+- url: https://example.com/channels.yaml
+  digest: ` + digest + `
+  http:
+    timeout: 10s
+prefixes:
+- prefix: v1-k3s-release
+  key: k3s
+  validation: warn
+- prefix: v1-*-release
+  key: "*"
+`,
+		},
+		{name: "unknown field", content: "sources:\n- url: https://example.com/channels.yaml\n  digests: " + digest + "\n", wantErr: `unknown field "digests"`},
+		{name: "invalid duration", content: "sources:\n- url: https://example.com/channels.yaml\n  http:\n    timeout: 10\n", wantErr: "duration must be a string"},
+		{name: "source without url", content: "overlays:\n- digest: " + digest + "\n", wantErr: "overlays[0] has no url"},
+		{name: "digest and digest URL", content: "sources:\n- url: https://example.com/channels.yaml\n  digest: " + digest + "\n  digestURL: https://example.com/channels.yaml.sha256\n", wantErr: "sources[0] sets both digest and digestURL"},
+		{name: "invalid digest", content: "sources:\n- url: https://example.com/channels.yaml\n  digest: latest\n", wantErr: "sources[0]: invalid SHA-256 digest"},
+		{name: "no prefix", content: "prefixes:\n- key: k3s\n", wantErr: "prefixes[0] has no prefix"},
+		{name: "repeated prefix", content: "prefixes:\n- prefix: v1-release\n- prefix: /v1-release/\n", wantErr: "prefixes[1] repeats prefix v1-release"},
+		{name: "every key without a wildcard", content: "prefixes:\n- prefix: v1-release\n  key: \"*\"\n", wantErr: "prefixes[0] must contain *"},
+		{name: "token and token file", content: "prefixes:\n- prefix: v1-release\n  githubToken: token\n  githubTokenFile: /run/secrets/token\n", wantErr: "sets both githubToken and githubTokenFile"},
+		{name: "unknown validation mode", content: "prefixes:\n- prefix: v1-release\n  validation: lenient\n", wantErr: "prefixes[0]: unknown validation mode"},
+		{name: "prefix source without url", content: "prefixes:\n- prefix: v1-release\n  sources:\n  - digest: " + digest + "\n", wantErr: "prefixes[0].sources[0] has no url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, t.TempDir(), "server.yaml", tt.content)
+			_, err := Load(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServedPrefixes(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := writeConfig(t, dir, "token", "file-token\n")
+	defaults := Prefix{
+		Sources:              []Source{{URL: "https://example.com/default.yaml"}},
+		ChannelServerVersion: "v2.9.0",
+		AppName:              "rancher",
+		GitHubToken:          "default-token",
+		Refresh:              "15m",
+		Validation:           "strict",
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		want   []Prefix
+	}{
+		{name: "no prefixes", config: Config{Sources: []Source{{URL: "https://example.com/channels.yaml"}}}},
+		{
+			name:   "defaults",
+			config: Config{Prefixes: []Prefix{{Prefix: "v1-release", Key: "k3s"}}},
+			want: []Prefix{{
+				Prefix: "v1-release", Key: "k3s", Sources: defaults.Sources, ChannelServerVersion: "v2.9.0",
+				AppName: "rancher", GitHubToken: "default-token", Refresh: "15m", Validation: "strict",
+			}},
+		},
+		{
+			name: "sources of the configuration",
+			config: Config{
+				Sources:  []Source{{URL: "https://example.com/channels.yaml"}},
+				Overlays: []Source{{URL: "https://example.com/overlay.yaml"}},
+				Prefixes: []Prefix{{Prefix: "v1-release", Key: "k3s"}},
+			},
+			want: []Prefix{{
+				Prefix: "v1-release", Key: "k3s", Sources: []Source{{URL: "https://example.com/channels.yaml"}},
+				Overlays: []Source{{URL: "https://example.com/overlay.yaml"}}, ChannelServerVersion: "v2.9.0",
+				AppName: "rancher", GitHubToken: "default-token", Refresh: "15m", Validation: "strict",
+			}},
+		},
+		{
+			name: "overridden",
+			config: Config{Prefixes: []Prefix{{
+				Prefix: "v1-release", Key: "k3s", Sources: []Source{{URL: "https://example.com/k3s.yaml"}},
+				ChannelServerVersion: "v2.10.0", AppName: "harvester", GitHubTokenFile: tokenFile, Refresh: "1h", Validation: "warn",
+			}}},
+			want: []Prefix{{
+				Prefix: "v1-release", Key: "k3s", Sources: []Source{{URL: "https://example.com/k3s.yaml"}},
+				ChannelServerVersion: "v2.10.0", AppName: "harvester", GitHubToken: "file-token", Refresh: "1h", Validation: "warn",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.ServedPrefixes(defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("got %s\nwant %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestHTTPOptions(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := writeConfig(t, dir, "password", "file-password\n")
+	defaults := config.HTTPOptions{
+		Timeout:  5 * time.Second,
+		Proxy:    "http://proxy.example.com:3128",
+		Username: "reader",
+		Headers:  map[string]string{"X-Default": "default", "X-Team": "k3s"},
+	}
+
+	tests := []struct {
+		name    string
+		http    *HTTP
+		want    config.HTTPOptions
+		wantErr bool
+	}{
+		{name: "not set", want: defaults},
+		{
+			name: "overridden",
+			http: &HTTP{Timeout: Duration{10 * time.Second}, PasswordFile: passwordFile, MaxBodySize: 1 << 20, Headers: map[string]string{"X-Team": "rke2"}},
+			want: config.HTTPOptions{
+				Timeout: 10 * time.Second, Proxy: defaults.Proxy, Username: "reader", Password: "file-password", MaxBodySize: 1 << 20,
+				Headers: map[string]string{"X-Default": "default", "X-Team": "rke2"},
+			},
+		},
+		{
+			name: "bearer token file",
+			http: &HTTP{BearerTokenFile: writeConfig(t, dir, "token", "file-token")},
+			want: config.HTTPOptions{Timeout: 5 * time.Second, Proxy: defaults.Proxy, Username: "reader", BearerToken: "file-token", Headers: defaults.Headers},
+		},
+		{name: "missing secret", http: &HTTP{PasswordFile: filepath.Join(dir, "missing")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.http.Options(defaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}